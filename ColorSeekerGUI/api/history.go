@@ -0,0 +1,99 @@
+package api
+
+import (
+	"sync"
+
+	"code-rewrite-runner/automation"
+)
+
+// record is an event with its position in the history. IDs start at 1 and
+// grow by one per event, so a client can tell which events it has missed.
+type record struct {
+	ID    uint64
+	Event automation.Event
+}
+
+// history keeps the last events in a ring buffer.
+type history struct {
+	mu     sync.Mutex
+	buf    []record
+	start  int // index of the oldest record in buf
+	count  int
+	lastID uint64
+
+	// changed is closed and replaced on every add, waking up all streams.
+	changed chan struct{}
+}
+
+func newHistory(size int) *history {
+	return &history{
+		buf:     make([]record, max(size, 1)),
+		changed: make(chan struct{}),
+	}
+}
+
+func (h *history) add(e automation.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastID++
+	rec := record{ID: h.lastID, Event: e}
+	if h.count < len(h.buf) {
+		h.buf[(h.start+h.count)%len(h.buf)] = rec
+		h.count++
+	} else {
+		h.buf[h.start] = rec
+		h.start = (h.start + 1) % len(h.buf)
+	}
+
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
+
+// size is the number of records the history can keep.
+func (h *history) size() int {
+	return len(h.buf)
+}
+
+// latest returns the ID of the newest event, 0 before the first one.
+func (h *history) latest() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.lastID
+}
+
+// since returns the records after id, oldest first, and how many of them
+// are no longer kept. changed is closed when the next event arrives.
+func (h *history) since(id uint64) (records []record, missed uint64, changed <-chan struct{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	changed = h.changed
+	if id >= h.lastID {
+		return nil, 0, changed
+	}
+
+	n := h.lastID - id
+	if n > uint64(h.count) {
+		missed = n - uint64(h.count)
+		n = uint64(h.count)
+	}
+	records = make([]record, n)
+	for i := range records {
+		records[i] = h.buf[(h.start+h.count-int(n)+i)%len(h.buf)]
+	}
+	return records, missed, changed
+}
+
+// last returns up to n newest records, oldest first.
+func (h *history) last(n int) []record {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	n = min(max(n, 0), h.count)
+	records := make([]record, n)
+	for i := range records {
+		records[i] = h.buf[(h.start+h.count-n+i)%len(h.buf)]
+	}
+	return records
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"testing"
+
+	"code-rewrite-runner/automation"
+)
+
+// testEvent is the n-th event of a test history; every third one is an
+// error, the others are info.
+func testEvent(n int) automation.Event {
+	if n%3 == 0 {
+		return &automation.EngineError{Header: automation.Header{Iteration: n}, Op: automation.OpCapture, Err: "нет кадра"}
+	}
+	return &automation.IterationStarted{Header: automation.Header{Iteration: n}}
+}
+
+func recordIDs(records []record) []uint64 {
+	ids := make([]uint64, len(records))
+	for i, rec := range records {
+		ids[i] = rec.ID
+	}
+	return ids
+}
+
+func equalIDs(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestHistorySince(t *testing.T) {
+	h := newHistory(3)
+	if records, missed, _ := h.since(0); len(records) != 0 || missed != 0 {
+		t.Errorf("empty history: %v records, %d missed", recordIDs(records), missed)
+	}
+	for n := 1; n <= 5; n++ {
+		h.add(testEvent(n))
+	}
+
+	for _, tc := range []struct {
+		id     uint64
+		want   []uint64
+		missed uint64
+	}{
+		{0, []uint64{3, 4, 5}, 2},
+		{1, []uint64{3, 4, 5}, 1},
+		{2, []uint64{3, 4, 5}, 0},
+		{4, []uint64{5}, 0},
+		{5, nil, 0},
+		{9, nil, 0},
+	} {
+		records, missed, _ := h.since(tc.id)
+		if got := recordIDs(records); !equalIDs(got, tc.want) || missed != tc.missed {
+			t.Errorf("since(%d) = %v with %d missed, want %v with %d", tc.id, got, missed, tc.want, tc.missed)
+		}
+	}
+
+	if got := recordIDs(h.last(2)); !equalIDs(got, []uint64{4, 5}) {
+		t.Errorf("last(2) = %v, want [4 5]", got)
+	}
+
+	_, _, changed := h.since(5)
+	h.add(testEvent(6))
+	select {
+	case <-changed:
+	default:
+		t.Error("changed was not closed by add")
+	}
+}
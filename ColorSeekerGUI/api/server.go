@@ -0,0 +1,359 @@
+// Package api serves a small HTTP/JSON interface to control an automation
+// engine from other tools. It listens on the local machine only and has no
+// authentication; requests naming another host or coming from a web page
+// elsewhere are refused.
+//
+//	GET  /api/state          состояние движка
+//	GET  /api/config         текущая конфигурация
+//	PUT  /api/config         заменить конфигурацию (с проверкой)
+//	POST /api/start          запустить
+//	POST /api/stop           остановить
+//	POST /api/pause          приостановить
+//	POST /api/resume         продолжить
+//	GET  /api/events?limit=N последние события
+//	GET  /api/stream         события по мере появления (Server-Sent Events)
+//	GET  /api/capture        снимок экрана в PNG
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"code-rewrite-runner/automation"
+	"code-rewrite-runner/render"
+)
+
+const (
+	defaultHistory = 500
+	defaultLimit   = 100
+)
+
+// Server is the control API of one engine.
+type Server struct {
+	engine   *automation.Engine
+	screen   automation.ScreenSource
+	onConfig func(automation.Config)
+	history  *history
+	events   *automation.Subscription
+	closed   chan struct{}
+}
+
+type Option func(*Server)
+
+// WithScreen sets the screen /api/capture reads; it should be the one the
+// engine searches. The default is the live display of the engine's config.
+func WithScreen(screen automation.ScreenSource) Option {
+	return func(s *Server) {
+		s.screen = screen
+	}
+}
+
+// WithConfigHook is called with every configuration accepted by PUT
+// /api/config, after it was handed to the engine. The GUI uses it to update
+// its fields and save the file.
+func WithConfigHook(hook func(automation.Config)) Option {
+	return func(s *Server) {
+		s.onConfig = hook
+	}
+}
+
+// WithHistory sets how many of the last events are kept for /api/events and
+// for streams resuming after a reconnect.
+func WithHistory(size int) Option {
+	return func(s *Server) {
+		s.history = newHistory(size)
+	}
+}
+
+// New creates the API of engine and starts recording its events. Close stops
+// the recording.
+func New(engine *automation.Engine, opts ...Option) *Server {
+	s := &Server{
+		engine:  engine,
+		history: newHistory(defaultHistory),
+		closed:  make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	// Blocking is safe here: recording only appends to the ring buffer, and
+	// it keeps the event IDs free of gaps that a client could not detect.
+	s.events = engine.Bus().Subscribe(256, automation.Block)
+	go func() {
+		for event := range s.events.Events() {
+			s.history.add(event)
+		}
+	}()
+	return s
+}
+
+// Close stops recording events and ends the open streams.
+func (s *Server) Close() {
+	s.events.Close()
+	close(s.closed)
+}
+
+// Handler returns the HTTP handler of the API. It only answers requests
+// whose Host is the local machine and that come from no web page or from one
+// served by the local machine too: any page in a browser can send requests to
+// 127.0.0.1, and through DNS rebinding read the answers, so loopback alone
+// would let it press keys and take screenshots.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/state", s.getState)
+	mux.HandleFunc("GET /api/config", s.getConfig)
+	mux.HandleFunc("PUT /api/config", s.putConfig)
+	mux.HandleFunc("POST /api/start", s.start)
+	mux.HandleFunc("POST /api/stop", s.stop)
+	mux.HandleFunc("POST /api/pause", s.pause)
+	mux.HandleFunc("POST /api/resume", s.resume)
+	mux.HandleFunc("GET /api/events", s.getEvents)
+	mux.HandleFunc("GET /api/stream", s.stream)
+	mux.HandleFunc("GET /api/capture", s.capture)
+	return localOnly(mux)
+}
+
+func localOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !automation.LoopbackHost(hostname(r.Host)) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("запрос к чужому хосту %q", r.Host))
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || !automation.LoopbackHost(u.Hostname()) {
+				writeError(w, http.StatusForbidden, fmt.Errorf("запрос со страницы %q", origin))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hostname strips the port, if any, from a Host header.
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+// ListenAndServe serves the API on addr, which must be a loopback address,
+// until ctx is cancelled.
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	if err := automation.CheckLoopback(addr); err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 5 * time.Second,
+		// Requests inherit ctx, so that open streams end with the server.
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		server.Shutdown(shutdown)
+	}()
+
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+type stateResponse struct {
+	State   automation.State `json:"state"`
+	Error   string           `json:"error,omitempty"`
+	Dropped uint64           `json:"dropped_events"`
+}
+
+func (s *Server) state() stateResponse {
+	response := stateResponse{
+		State:   s.engine.State(),
+		Dropped: s.engine.Bus().Dropped(),
+	}
+	if err := s.engine.Err(); err != nil {
+		response.Error = err.Error()
+	}
+	return response
+}
+
+func (s *Server) getState(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.state())
+}
+
+func (s *Server) getConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.engine.Config())
+}
+
+// putConfig replaces the whole configuration. Fields left out of the body get
+// their defaults, as in a config file. A running loop keeps its configuration
+// until it is restarted.
+func (s *Server) putConfig(w http.ResponseWriter, r *http.Request) {
+	config := automation.DefaultConfig()
+	config.Rules = nil
+
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("неверный JSON конфигурации: %w", err))
+		return
+	}
+	if err := config.Validate(); err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, errorResponse{
+			Error:  "конфигурация не прошла проверку",
+			Errors: automation.Problems(err),
+		})
+		return
+	}
+
+	s.engine.SetConfig(config)
+	if s.onConfig != nil {
+		s.onConfig(config)
+	}
+	writeJSON(w, http.StatusOK, config)
+}
+
+func (s *Server) start(w http.ResponseWriter, r *http.Request) {
+	if err := s.engine.Config().Validate(); err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, errorResponse{
+			Error:  "конфигурация не прошла проверку",
+			Errors: automation.Problems(err),
+		})
+		return
+	}
+	s.command(w, s.engine.Start(context.Background()))
+}
+
+func (s *Server) stop(w http.ResponseWriter, r *http.Request) {
+	s.engine.Stop()
+	s.command(w, nil)
+}
+
+func (s *Server) pause(w http.ResponseWriter, r *http.Request) {
+	s.command(w, s.engine.Pause())
+}
+
+func (s *Server) resume(w http.ResponseWriter, r *http.Request) {
+	s.command(w, s.engine.Resume())
+}
+
+// command answers a state change with the new state, or 409 if the engine
+// was in the wrong state for it.
+func (s *Server) command(w http.ResponseWriter, err error) {
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.state())
+}
+
+// getEvents returns the last events, oldest first, each as rendered by
+// render.JSON with its history "id" added. The level parameter filters them
+// like in stream.
+func (s *Server) getEvents(w http.ResponseWriter, r *http.Request) {
+	levels, err := parseLevels(r.URL.Query().Get("level"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	limit := defaultLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("неверный limit: %q", value))
+			return
+		}
+		limit = n
+	}
+
+	var records []record
+	for _, rec := range s.history.last(s.history.size()) {
+		if levels.match(rec.Event) {
+			records = append(records, rec)
+		}
+	}
+	records = records[max(len(records)-limit, 0):]
+
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for _, rec := range records {
+		data, err := eventJSON(rec)
+		if err != nil {
+			continue
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		buf.Write(data)
+	}
+	buf.WriteString("]\n")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(buf.Bytes())
+}
+
+func (s *Server) capture(w http.ResponseWriter, r *http.Request) {
+	screen := s.screen
+	if screen == nil {
+		screen = automation.NewLiveScreen(s.engine.Config().Display)
+	}
+
+	img, err := screen.Capture(screen.Bounds())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("ошибка захвата экрана: %w", err))
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(buf.Bytes())
+}
+
+// eventJSON renders rec like render.JSON, with "id" as the first field.
+func eventJSON(rec record) ([]byte, error) {
+	data, err := render.JSON(rec.Event)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(fmt.Sprintf(`{"id":%d,`, rec.ID)), data[1:]...), nil
+}
+
+type errorResponse struct {
+	Error  string   `json:"error"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
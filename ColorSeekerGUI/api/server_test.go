@@ -0,0 +1,42 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"code-rewrite-runner/automation"
+)
+
+func TestHandlerRefusesForeignHostAndOrigin(t *testing.T) {
+	engine := automation.NewEngine(automation.DefaultConfig(), automation.NewBus())
+	server := New(engine)
+	defer server.Close()
+	handler := server.Handler()
+
+	for _, tc := range []struct {
+		host, origin string
+		want         int
+	}{
+		{"127.0.0.1:8765", "", http.StatusOK},
+		{"localhost:8765", "", http.StatusOK},
+		{"[::1]:8765", "", http.StatusOK},
+		{"localhost", "http://localhost:3000", http.StatusOK},
+		{"127.0.0.1:8765", "http://[::1]:8080", http.StatusOK},
+		{"attacker.example:8765", "", http.StatusForbidden},
+		{"127.0.0.1.attacker.example:8765", "", http.StatusForbidden},
+		{"127.0.0.1:8765", "https://attacker.example", http.StatusForbidden},
+		{"127.0.0.1:8765", "null", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/api/state", nil)
+		req.Host = tc.host
+		if tc.origin != "" {
+			req.Header.Set("Origin", tc.origin)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("Host %q, Origin %q: status %d, want %d", tc.host, tc.origin, rec.Code, tc.want)
+		}
+	}
+}
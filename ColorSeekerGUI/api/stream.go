@@ -0,0 +1,129 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"code-rewrite-runner/automation"
+)
+
+const keepAlive = 15 * time.Second
+
+// levelFilter is the set of levels a client asked for; empty passes all.
+type levelFilter map[automation.Level]bool
+
+// parseLevels reads a comma separated list like "warning,error".
+func parseLevels(value string) (levelFilter, error) {
+	filter := levelFilter{}
+	for _, name := range strings.Split(value, ",") {
+		level := automation.Level(strings.TrimSpace(name))
+		switch level {
+		case "":
+		case automation.LevelInfo, automation.LevelSuccess, automation.LevelWarning, automation.LevelError:
+			filter[level] = true
+		default:
+			return nil, fmt.Errorf("неизвестный уровень %q: допустимы info, success, warning, error", level)
+		}
+	}
+	return filter, nil
+}
+
+func (f levelFilter) match(e automation.Event) bool {
+	return len(f) == 0 || f[e.Level()]
+}
+
+// stream pushes events as Server-Sent Events as they are published. Every
+// message carries the history ID as its id and the JSON of /api/events as its
+// data.
+//
+// A client resuming after a reconnect sends the last ID it saw in the
+// Last-Event-ID header, which browsers do on their own, or in the
+// last_event_id parameter; it first gets the events it missed that are still
+// in the history. If some are gone already, a "lost" message with their count
+// comes before them. Without an ID the stream starts with the next event.
+func (s *Server) stream(w http.ResponseWriter, r *http.Request) {
+	levels, err := parseLevels(r.URL.Query().Get("level"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	latest := s.history.latest()
+	last := latest
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = r.URL.Query().Get("last_event_id")
+	}
+	if value != "" {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("неверный Last-Event-ID: %q", value))
+			return
+		}
+		// An ID from the future belongs to an earlier run of the program,
+		// whose events are gone; start over with the next event.
+		last = min(id, latest)
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: 2000\n\n")
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+
+	for {
+		records, missed, changed := s.history.since(last)
+		if missed > 0 {
+			fmt.Fprintf(w, "event: lost\ndata: {\"missed\":%d}\n\n", missed)
+		}
+		for _, rec := range records {
+			last = rec.ID
+			if !levels.match(rec.Event) {
+				continue
+			}
+			if err := writeMessage(w, rec); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-ticker.C:
+			// A comment line keeps proxies and idle timeouts from closing
+			// a quiet stream.
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+func writeMessage(w io.Writer, rec record) error {
+	data, err := eventJSON(rec)
+	if err != nil {
+		return nil
+	}
+	_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", rec.ID, data)
+	return err
+}
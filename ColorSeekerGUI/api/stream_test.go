@@ -0,0 +1,196 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"code-rewrite-runner/automation"
+)
+
+// message is one Server-Sent Event.
+type message struct {
+	ID    uint64
+	Event string
+	Data  string
+}
+
+// openStream connects to /api/stream with the query and Last-Event-ID,
+// and returns the messages as they arrive once the stream has begun.
+func openStream(t *testing.T, url, query, lastID string) <-chan message {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/api/stream"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+
+	// The retry line comes first; once it is read, the stream has taken
+	// its starting point in the history.
+	lines := bufio.NewScanner(resp.Body)
+	if !lines.Scan() || !strings.HasPrefix(lines.Text(), "retry:") {
+		t.Fatalf("the stream began with %q", lines.Text())
+	}
+
+	messages := make(chan message)
+	go func() {
+		defer close(messages)
+		var m message
+		for lines.Scan() {
+			field, value, _ := strings.Cut(lines.Text(), ": ")
+			switch field {
+			case "id":
+				m.ID, _ = strconv.ParseUint(value, 10, 64)
+			case "event":
+				m.Event = value
+			case "data":
+				m.Data = value
+			case "":
+				if m.Data != "" {
+					messages <- m
+				}
+				m = message{}
+			}
+		}
+	}()
+	return messages
+}
+
+// receive returns the next n messages.
+func receive(t *testing.T, messages <-chan message, n int) []message {
+	t.Helper()
+	var got []message
+	for len(got) < n {
+		select {
+		case m, ok := <-messages:
+			if !ok {
+				t.Fatalf("the stream ended after %v", got)
+			}
+			got = append(got, m)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %v, want %d messages", got, n)
+		}
+	}
+	return got
+}
+
+// streamServer serves an API whose history keeps size events and holds the
+// events 1 to count.
+func streamServer(t *testing.T, size, count int) (*Server, string) {
+	t.Helper()
+	server := New(automation.NewEngine(automation.DefaultConfig(), automation.NewBus()), WithHistory(size))
+	for n := 1; n <= count; n++ {
+		server.history.add(testEvent(n))
+	}
+	ts := httptest.NewServer(server.Handler())
+	t.Cleanup(func() {
+		server.Close()
+		ts.Close()
+	})
+	return server, ts.URL
+}
+
+func TestStreamResumes(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		size, count   int
+		query, lastID string
+		lost          int
+		want          []uint64
+	}{
+		{"Last-Event-ID", 10, 5, "", "3", 0, []uint64{4, 5}},
+		{"parameter", 10, 5, "?last_event_id=2", "", 0, []uint64{3, 4, 5}},
+		{"wrapped", 3, 6, "", "1", 2, []uint64{4, 5, 6}},
+		{"level", 10, 7, "?level=error", "0", 0, []uint64{3, 6}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server, url := streamServer(t, tc.size, tc.count)
+			messages := openStream(t, url, tc.query, tc.lastID)
+
+			n := len(tc.want)
+			if tc.lost > 0 {
+				n++
+			}
+			got := receive(t, messages, n)
+			if tc.lost > 0 {
+				var lost struct {
+					Missed int `json:"missed"`
+				}
+				err := json.Unmarshal([]byte(got[0].Data), &lost)
+				if err != nil || got[0].Event != "lost" || lost.Missed != tc.lost {
+					t.Errorf("began with %+v, want %d lost", got[0], tc.lost)
+				}
+				got = got[1:]
+			}
+			for i, m := range got {
+				if m.ID != tc.want[i] || !strings.Contains(m.Data, `"id":`+strconv.FormatUint(m.ID, 10)) {
+					t.Errorf("message %d: %+v, want ID %d", i, m, tc.want[i])
+				}
+			}
+
+			// The backlog is followed by the live events that pass the filter.
+			server.history.add(testEvent(8))
+			server.history.add(testEvent(9))
+			want := uint64(tc.count + 1)
+			if tc.query == "?level=error" {
+				want++
+			}
+			if next := receive(t, messages, 1)[0]; next.ID != want {
+				t.Errorf("live message %+v, want ID %d", next, want)
+			}
+		})
+	}
+}
+
+func TestStreamStartsWithNextEvent(t *testing.T) {
+	// No ID, or one from an earlier run of the program beyond the history.
+	for _, lastID := range []string{"", "100"} {
+		server, url := streamServer(t, 10, 5)
+		messages := openStream(t, url, "", lastID)
+		server.history.add(testEvent(6))
+		if got := receive(t, messages, 1)[0]; got.ID != 6 || got.Event != "" {
+			t.Errorf("Last-Event-ID %q: first message %+v, want ID 6", lastID, got)
+		}
+	}
+}
+
+func TestStreamRejectsBadParameters(t *testing.T) {
+	_, url := streamServer(t, 10, 5)
+	for _, tc := range []struct{ query, lastID string }{
+		{"?level=debug", ""},
+		{"", "abc"},
+		{"?last_event_id=-1", ""},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, url+"/api/stream"+tc.query, nil)
+		if tc.lastID != "" {
+			req.Header.Set("Last-Event-ID", tc.lastID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%q, Last-Event-ID %q: status %d, want %d", tc.query, tc.lastID, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
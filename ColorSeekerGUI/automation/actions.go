@@ -0,0 +1,442 @@
+package automation
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"strings"
+	"time"
+)
+
+// Action types:
+//
+//   - ActionClick: click Template with Button ("left", "right", "middle"),
+//     optionally Double and with Modifiers held down.
+//   - ActionHold: press Button on Template and keep it down for DurationMs.
+//   - ActionKey: press Key with Modifiers. Key may also be a chord written as
+//     "ctrl+shift+s".
+//   - ActionType: type Text.
+//   - ActionScroll: scroll the wheel by ScrollX/ScrollY, over Template if set.
+//   - ActionDrag: drag from Template to the best match of To.
+//   - ActionWait: pause for DurationMs.
+const (
+	ActionClick  = "click"
+	ActionHold   = "hold"
+	ActionKey    = "key"
+	ActionType   = "type"
+	ActionScroll = "scroll"
+	ActionDrag   = "drag"
+	ActionWait   = "wait"
+)
+
+// Action is one step of a rule. For template actions Select picks which
+// occurrence is used (see the Click* constants); NearX/NearY is the reference
+// point for ClickNearest.
+type Action struct {
+	Type     string       `json:"type"`
+	Template *TemplateRef `json:"template,omitempty"`
+	Select   string       `json:"select,omitempty"`
+	NearX    int          `json:"near_x,omitempty"`
+	NearY    int          `json:"near_y,omitempty"`
+
+	Button     string       `json:"button,omitempty"`
+	Double     bool         `json:"double,omitempty"`
+	Modifiers  []string     `json:"modifiers,omitempty"`
+	Key        string       `json:"key,omitempty"`
+	Text       string       `json:"text,omitempty"`
+	ScrollX    int          `json:"scroll_x,omitempty"`
+	ScrollY    int          `json:"scroll_y,omitempty"`
+	To         *TemplateRef `json:"to,omitempty"`
+	DurationMs int          `json:"duration_ms,omitempty"`
+
+	Anchor      string  `json:"anchor,omitempty"`
+	OffsetX     int     `json:"offset_x,omitempty"`
+	OffsetY     int     `json:"offset_y,omitempty"`
+	OffsetFracX float64 `json:"offset_frac_x,omitempty"`
+	OffsetFracY float64 `json:"offset_frac_y,omitempty"`
+
+	Verify *Verify `json:"verify,omitempty"`
+}
+
+// Click anchors for Action.Anchor. The anchor point is moved by
+// OffsetX/OffsetY pixels plus OffsetFracX/OffsetFracY times the matched
+// template size.
+const (
+	AnchorCenter      = "center"
+	AnchorTopLeft     = "top_left"
+	AnchorTop         = "top"
+	AnchorTopRight    = "top_right"
+	AnchorLeft        = "left"
+	AnchorRight       = "right"
+	AnchorBottomLeft  = "bottom_left"
+	AnchorBottom      = "bottom"
+	AnchorBottomRight = "bottom_right"
+)
+
+// point returns where the action should act on match.
+func (a Action) point(m Match) image.Point {
+	x, y := m.Location.X+m.Size.X/2, m.Location.Y+m.Size.Y/2
+	left, top := m.Location.X, m.Location.Y
+	right, bottom := m.Location.X+m.Size.X-1, m.Location.Y+m.Size.Y-1
+
+	switch a.Anchor {
+	case AnchorTopLeft:
+		x, y = left, top
+	case AnchorTop:
+		y = top
+	case AnchorTopRight:
+		x, y = right, top
+	case AnchorLeft:
+		x = left
+	case AnchorRight:
+		x = right
+	case AnchorBottomLeft:
+		x, y = left, bottom
+	case AnchorBottom:
+		y = bottom
+	case AnchorBottomRight:
+		x, y = right, bottom
+	}
+
+	x += a.OffsetX + int(math.Round(a.OffsetFracX*float64(m.Size.X)))
+	y += a.OffsetY + int(math.Round(a.OffsetFracY*float64(m.Size.Y)))
+	return image.Point{X: x, Y: y}
+}
+
+func (a Action) anchorName() string {
+	if a.Anchor == "" {
+		return AnchorCenter
+	}
+	return a.Anchor
+}
+
+func (a Action) hasOffset() bool {
+	return (a.Anchor != "" && a.Anchor != AnchorCenter) ||
+		a.OffsetX != 0 || a.OffsetY != 0 || a.OffsetFracX != 0 || a.OffsetFracY != 0
+}
+
+func (a Action) button() string {
+	switch a.Button {
+	case "", "left":
+		return "left"
+	case "middle", "center":
+		return "center"
+	default:
+		return a.Button
+	}
+}
+
+// chord splits Key into the key itself and its modifiers, so that "ctrl+s"
+// and Key "s" with Modifiers ["ctrl"] mean the same. A lone "+", or one
+// after the last separator as in "ctrl++", is the plus key.
+func (a Action) chord() (string, []string) {
+	spec, key := a.Key, "+"
+	switch {
+	case spec == "+":
+		spec = ""
+	case strings.HasSuffix(spec, "++"):
+		spec = strings.TrimSuffix(spec, "++")
+	default:
+		i := strings.LastIndex(spec, "+")
+		spec, key = spec[:max(i, 0)], spec[i+1:]
+	}
+
+	modifiers := append([]string(nil), a.Modifiers...)
+	if spec != "" {
+		modifiers = append(modifiers, strings.Split(spec, "+")...)
+	}
+	return key, modifiers
+}
+
+func (a Action) String() string {
+	text := a.describe()
+	if a.Verify != nil {
+		if expect, ok := a.Verify.expect(a); ok {
+			text += fmt.Sprintf(" {проверка: %s, повторов: %d}", expect, a.Verify.Retries)
+		}
+	}
+	return text
+}
+
+func (a Action) describe() string {
+	name := func(ref *TemplateRef) string {
+		if ref == nil {
+			return "?"
+		}
+		return ref.Path
+	}
+
+	var text string
+	switch a.Type {
+	case ActionClick:
+		text = "клик " + name(a.Template)
+		if a.button() != "left" {
+			text += " " + a.Button
+		}
+		if a.Double {
+			text += " ×2"
+		}
+	case ActionHold:
+		text = fmt.Sprintf("удержание %s %d мс", name(a.Template), a.DurationMs)
+	case ActionKey:
+		key, modifiers := a.chord()
+		text = "клавиша " + strings.Join(append(modifiers, key), "+")
+		return text
+	case ActionType:
+		return fmt.Sprintf("ввод %q", a.Text)
+	case ActionScroll:
+		text = fmt.Sprintf("прокрутка %d,%d", a.ScrollX, a.ScrollY)
+		if a.Template != nil {
+			text += " над " + a.Template.Path
+		}
+	case ActionDrag:
+		text = fmt.Sprintf("перетаскивание %s → %s", name(a.Template), name(a.To))
+	case ActionWait:
+		return fmt.Sprintf("пауза %d мс", a.DurationMs)
+	default:
+		return a.Type
+	}
+
+	if len(a.Modifiers) > 0 {
+		text += " [" + strings.Join(a.Modifiers, "+") + "]"
+	}
+	if a.hasOffset() {
+		text += fmt.Sprintf(" @%s%+d%+d", a.anchorName(), a.OffsetX, a.OffsetY)
+		if a.OffsetFracX != 0 || a.OffsetFracY != 0 {
+			text += fmt.Sprintf("%+.2fw%+.2fh", a.OffsetFracX, a.OffsetFracY)
+		}
+	}
+	if a.Select != "" && a.Select != ClickBest {
+		text += " (" + a.Select + ")"
+	}
+	return text
+}
+
+// runActions performs the actions in order and stops at the first one that
+// fails, since later steps usually depend on the earlier ones.
+func (r *runner) runActions(it *iteration, actions []Action) {
+	for _, action := range actions {
+		if r.ctx.Err() != nil {
+			return
+		}
+
+		ok := r.perform(it, action)
+		if ok && action.Verify != nil {
+			ok = r.verify(action)
+		}
+		if !ok {
+			return
+		}
+	}
+}
+
+func (r *runner) perform(it *iteration, action Action) bool {
+	if input, ok := r.input.(unavailable); ok && action.Type != ActionWait {
+		r.actionFailed(action, input.Unavailable())
+		return false
+	}
+
+	switch action.Type {
+	case ActionClick:
+		return r.clickTemplate(it, action)
+	case ActionHold:
+		return r.holdTemplate(it, action)
+	case ActionKey:
+		return r.pressKey(action)
+	case ActionType:
+		return r.typeText(action)
+	case ActionScroll:
+		return r.scroll(it, action)
+	case ActionDrag:
+		return r.drag(it, action)
+	case ActionWait:
+		r.emit(&ActionPerformed{Action: action})
+		return r.sleep(time.Duration(action.DurationMs) * time.Millisecond)
+	default:
+		r.actionFailed(action, fmt.Errorf("неизвестное действие: %s", action.Type))
+		return false
+	}
+}
+
+func (r *runner) actionFailed(action Action, err error) {
+	r.emit(&EngineError{Op: OpAction, Action: &action, Err: err.Error()})
+}
+
+// onScreen reports whether p lies on the display, reporting an error otherwise.
+func (r *runner) onScreen(action Action, p image.Point) bool {
+	if p.In(r.screen.Bounds()) {
+		return true
+	}
+
+	r.actionFailed(action, fmt.Errorf("точка X=%d, Y=%d вне экрана %v", p.X, p.Y, r.screen.Bounds()))
+	return false
+}
+
+// holdModifiers presses the modifiers and returns a func releasing them in
+// reverse order.
+func (r *runner) holdModifiers(modifiers []string) func() {
+	for _, modifier := range modifiers {
+		r.input.KeyToggle(modifier, true)
+	}
+	return func() {
+		for i := len(modifiers) - 1; i >= 0; i-- {
+			r.input.KeyToggle(modifiers[i], false)
+		}
+	}
+}
+
+func (r *runner) templateTargets(it *iteration, action Action) []Match {
+	if action.Template == nil {
+		r.actionFailed(action, fmt.Errorf("для действия %s не указан шаблон", action.Type))
+		return nil
+	}
+
+	targets := r.selectTargets(it, *action.Template, action)
+	if len(targets) == 0 {
+		r.actionFailed(action, fmt.Errorf("изображение %s не найдено", action.Template.Path))
+	}
+	return targets
+}
+
+func (r *runner) clickTemplate(it *iteration, action Action) bool {
+	targets := r.templateTargets(it, action)
+	if len(targets) == 0 {
+		return false
+	}
+
+	button := action.button()
+	clicked := 0
+	for _, match := range targets {
+		center := action.point(match)
+		if !r.onScreen(action, center) {
+			continue
+		}
+
+		r.input.Move(center.X, center.Y)
+		time.Sleep(50 * time.Millisecond)
+		release := r.holdModifiers(action.Modifiers)
+		r.input.Click(button, action.Double)
+		release()
+
+		r.emit(&ActionPerformed{Action: action, Point: center, Match: &match})
+		clicked++
+	}
+
+	return clicked > 0
+}
+
+func (r *runner) holdTemplate(it *iteration, action Action) bool {
+	targets := r.templateTargets(it, action)
+	if len(targets) == 0 {
+		return false
+	}
+
+	center := action.point(targets[0])
+	if !r.onScreen(action, center) {
+		return false
+	}
+	button := action.button()
+
+	r.input.Move(center.X, center.Y)
+	time.Sleep(50 * time.Millisecond)
+	release := r.holdModifiers(action.Modifiers)
+	r.input.MouseToggle(button, true)
+	r.emit(&ActionPerformed{Action: action, Point: center, Match: &targets[0]})
+	ok := r.sleep(time.Duration(action.DurationMs) * time.Millisecond)
+	r.input.MouseToggle(button, false)
+	release()
+
+	return ok
+}
+
+func (r *runner) pressKey(action Action) bool {
+	key, modifiers := action.chord()
+	if key == "" {
+		r.actionFailed(action, errors.New("для нажатия не указана клавиша"))
+		return false
+	}
+
+	if err := r.input.Press(key, modifiers...); err != nil {
+		r.emit(&EngineError{Op: OpInput, Action: &action, Err: fmt.Sprintf("%s: %v", key, err)})
+		return false
+	}
+	r.emit(&ActionPerformed{Action: action})
+	return true
+}
+
+func (r *runner) typeText(action Action) bool {
+	r.input.Type(action.Text)
+	r.emit(&ActionPerformed{Action: action})
+	return true
+}
+
+func (r *runner) scroll(it *iteration, action Action) bool {
+	event := &ActionPerformed{Action: action}
+	if action.Template != nil {
+		targets := r.templateTargets(it, action)
+		if len(targets) == 0 {
+			return false
+		}
+		center := action.point(targets[0])
+		if !r.onScreen(action, center) {
+			return false
+		}
+		r.input.Move(center.X, center.Y)
+		event.Point, event.Match = center, &targets[0]
+	}
+
+	r.input.Scroll(action.ScrollX, action.ScrollY)
+	r.emit(event)
+	return true
+}
+
+func (r *runner) drag(it *iteration, action Action) bool {
+	if action.To == nil {
+		r.actionFailed(action, errors.New("для перетаскивания не указан целевой шаблон"))
+		return false
+	}
+
+	from := r.templateTargets(it, action)
+	if len(from) == 0 {
+		return false
+	}
+	to, ok := r.bestMatch(it, *action.To)
+	if !ok {
+		r.actionFailed(action, fmt.Errorf("изображение %s не найдено", action.To.Path))
+		return false
+	}
+
+	start, end := action.point(from[0]), to.Center()
+	if !r.onScreen(action, start) || !r.onScreen(action, end) {
+		return false
+	}
+	button := action.button()
+
+	r.input.Move(start.X, start.Y)
+	time.Sleep(50 * time.Millisecond)
+	r.input.MouseToggle(button, true)
+
+	// Move in a few steps so that the target application sees a drag.
+	const steps = 10
+	for i := 1; i <= steps; i++ {
+		r.input.Move(start.X+(end.X-start.X)*i/steps, start.Y+(end.Y-start.Y)*i/steps)
+		time.Sleep(20 * time.Millisecond)
+	}
+	r.input.MouseToggle(button, false)
+
+	r.emit(&ActionPerformed{Action: action, Point: start, To: end, Match: &from[0]})
+	return true
+}
+
+func (r *runner) selectTargets(it *iteration, ref TemplateRef, action Action) []Match {
+	if action.Select == "" || action.Select == ClickBest {
+		if match, ok := r.bestMatch(it, ref); ok {
+			return []Match{match}
+		}
+		return nil
+	}
+
+	matches := r.allMatches(it, ref)
+	return selectMatches(matches, action.Select, image.Point{X: action.NearX, Y: action.NearY})
+}
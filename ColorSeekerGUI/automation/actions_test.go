@@ -0,0 +1,64 @@
+package automation
+
+import (
+	"image"
+	"strings"
+	"testing"
+)
+
+func TestChord(t *testing.T) {
+	for _, tc := range []struct {
+		key, modifiers string
+		want, wantMods string
+	}{
+		{"s", "", "s", ""},
+		{"ctrl+shift+s", "", "s", "ctrl shift"},
+		{"s", "alt", "s", "alt"},
+		{"+", "", "+", ""},
+		{"ctrl++", "", "+", "ctrl"},
+		{"ctrl+shift++", "alt", "+", "alt ctrl shift"},
+		{"ctrl+", "", "", "ctrl"},
+	} {
+		action := Action{Type: ActionKey, Key: tc.key, Modifiers: strings.Fields(tc.modifiers)}
+		key, modifiers := action.chord()
+		if key != tc.want || strings.Join(modifiers, " ") != tc.wantMods {
+			t.Errorf("chord of %q with %q = %q, %q; want %q, %q", tc.key, tc.modifiers, key, modifiers, tc.want, tc.wantMods)
+		}
+	}
+}
+
+func TestActionPoint(t *testing.T) {
+	// The match covers x 10..40 and y 20..40.
+	m := Match{Location: image.Pt(10, 20), Size: image.Pt(31, 21)}
+	for _, tc := range []struct {
+		action Action
+		want   image.Point
+	}{
+		{Action{}, image.Pt(25, 30)},
+		{Action{Anchor: AnchorCenter}, image.Pt(25, 30)},
+		{Action{Anchor: AnchorTopLeft}, image.Pt(10, 20)},
+		{Action{Anchor: AnchorTop}, image.Pt(25, 20)},
+		{Action{Anchor: AnchorTopRight}, image.Pt(40, 20)},
+		{Action{Anchor: AnchorLeft}, image.Pt(10, 30)},
+		{Action{Anchor: AnchorRight}, image.Pt(40, 30)},
+		{Action{Anchor: AnchorBottomLeft}, image.Pt(10, 40)},
+		{Action{Anchor: AnchorBottom}, image.Pt(25, 40)},
+		{Action{Anchor: AnchorBottomRight}, image.Pt(40, 40)},
+		{Action{OffsetX: 5, OffsetY: -3}, image.Pt(30, 27)},
+		// Fractions of the size are rounded: 0.5 × 31 to 16, -0.25 × 21 to -5.
+		{Action{OffsetFracX: 0.5, OffsetFracY: -0.25}, image.Pt(41, 25)},
+		{Action{Anchor: AnchorBottomRight, OffsetX: 2, OffsetFracX: -1, OffsetY: 4}, image.Pt(11, 44)},
+		{Action{Anchor: AnchorTopLeft, OffsetFracX: 1, OffsetFracY: 1}, image.Pt(41, 41)},
+	} {
+		if got := tc.action.point(m); got != tc.want {
+			t.Errorf("%s anchor, offset %d,%d, fraction %v,%v: %v, want %v", tc.action.anchorName(),
+				tc.action.OffsetX, tc.action.OffsetY, tc.action.OffsetFracX, tc.action.OffsetFracY, got, tc.want)
+		}
+	}
+
+	// An even size has no middle pixel; the center is the one right of and
+	// below it.
+	if got := (Action{}).point(Match{Location: image.Pt(10, 20), Size: image.Pt(30, 20)}); got != image.Pt(25, 30) {
+		t.Errorf("center of 30×20 at (10,20) = %v, want (25,30)", got)
+	}
+}
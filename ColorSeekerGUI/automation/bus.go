@@ -0,0 +1,179 @@
+package automation
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Overflow policies for a full subscriber queue:
+//
+//   - DropOldest: discard the oldest queued event to make room. Good for live
+//     views that care about the latest state, like the GUI log.
+//   - DropNewest: discard the event being published.
+//   - Block: wait until the subscriber catches up. The publisher, and so the
+//     automation loop, stalls with it; use it only for consumers that must
+//     see every event and are known to keep up.
+type Overflow string
+
+const (
+	DropOldest Overflow = "drop_oldest"
+	DropNewest Overflow = "drop_newest"
+	Block      Overflow = "block"
+)
+
+// Bus fans events out to any number of subscribers, each with its own bounded
+// queue, so one slow observer neither stalls the engine (unless it asked for
+// Block) nor starves the others. All subscribers receive the same event
+// values, which must be treated as read-only. It is safe for concurrent use.
+type Bus struct {
+	mu      sync.RWMutex
+	subs    map[*Subscription]struct{}
+	closed  bool
+	dropped atomic.Uint64
+
+	done     chan struct{} // closed by Close before it takes mu
+	doneOnce sync.Once
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*Subscription]struct{}), done: make(chan struct{})}
+}
+
+// Subscription is one subscriber queue of a Bus.
+type Subscription struct {
+	bus      *Bus
+	events   chan Event
+	overflow Overflow
+	dropped  atomic.Uint64
+
+	mu       sync.Mutex // serializes DropOldest's make-room-then-send
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+// Subscribe adds a subscriber with a queue of size events. The channel of a
+// closed bus is returned already closed.
+func (b *Bus) Subscribe(size int, overflow Overflow) *Subscription {
+	s := &Subscription{
+		bus:      b,
+		events:   make(chan Event, max(size, 1)),
+		overflow: overflow,
+		done:     make(chan struct{}),
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(s.events)
+		s.doneOnce.Do(func() { close(s.done) })
+		return s
+	}
+	b.subs[s] = struct{}{}
+	return s
+}
+
+// Publish delivers e to every subscriber according to its overflow policy.
+func (b *Bus) Publish(e Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return
+	}
+
+	for s := range b.subs {
+		if n := s.deliver(e); n > 0 {
+			s.dropped.Add(n)
+			b.dropped.Add(n)
+		}
+	}
+}
+
+// Dropped is the number of events lost over all subscribers, past and present.
+func (b *Bus) Dropped() uint64 {
+	return b.dropped.Load()
+}
+
+// Close closes every subscriber channel; later publishes are discarded.
+func (b *Bus) Close() {
+	// Release blocked publishers first, as Subscription.Close does: they
+	// hold the read lock that Close has to wait for.
+	b.doneOnce.Do(func() { close(b.done) })
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for s := range b.subs {
+		s.doneOnce.Do(func() { close(s.done) })
+		close(s.events)
+		delete(b.subs, s)
+	}
+}
+
+// Events is the subscriber's queue. It is closed by Close or Bus.Close.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Dropped is the number of events this subscriber lost to overflow.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close unsubscribes. A publisher blocked on this subscriber is released.
+func (s *Subscription) Close() {
+	// Release a blocked publisher first: it holds the read lock that the
+	// removal below has to wait for.
+	s.doneOnce.Do(func() { close(s.done) })
+
+	b := s.bus
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.events)
+	}
+}
+
+// deliver queues e and returns how many events it had to drop.
+func (s *Subscription) deliver(e Event) uint64 {
+	select {
+	case s.events <- e:
+		return 0
+	default:
+	}
+
+	switch s.overflow {
+	case Block:
+		select {
+		case s.events <- e:
+			return 0
+		case <-s.done:
+			return 1
+		case <-s.bus.done:
+			return 1
+		}
+	case DropNewest:
+		return 1
+	default:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		var dropped uint64
+		for {
+			select {
+			case s.events <- e:
+				return dropped
+			default:
+			}
+			// The consumer may empty the queue between the two selects, so
+			// the receive must not block either.
+			select {
+			case <-s.events:
+				dropped++
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package automation
+
+import (
+	"testing"
+	"time"
+)
+
+// numbered is an event that carries its publishing order.
+func numbered(n int) Event {
+	return &IterationStarted{Header: Header{Iteration: n}}
+}
+
+func drain(s *Subscription) []int {
+	var got []int
+	for e := range s.Events() {
+		got = append(got, e.header().Iteration)
+	}
+	return got
+}
+
+// returns fails the test unless f returns within a second.
+func returns(t *testing.T, what string, f func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		f()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("%s did not return", what)
+	}
+}
+
+func TestBusOverflow(t *testing.T) {
+	for _, tc := range []struct {
+		overflow Overflow
+		want     []int
+	}{
+		{DropOldest, []int{4, 5}},
+		{DropNewest, []int{1, 2}},
+	} {
+		bus := NewBus()
+		s := bus.Subscribe(2, tc.overflow)
+		roomy := bus.Subscribe(8, tc.overflow)
+		for n := 1; n <= 5; n++ {
+			bus.Publish(numbered(n))
+		}
+		bus.Close()
+
+		if got := drain(s); !equalInts(got, tc.want) {
+			t.Errorf("%s: received %v, want %v", tc.overflow, got, tc.want)
+		}
+		if got := drain(roomy); len(got) != 5 {
+			t.Errorf("%s: a large enough queue received %v, want all 5", tc.overflow, got)
+		}
+		if s.Dropped() != 3 || roomy.Dropped() != 0 || bus.Dropped() != 3 {
+			t.Errorf("%s: dropped %d, %d and %d on the bus, want 3, 0 and 3",
+				tc.overflow, s.Dropped(), roomy.Dropped(), bus.Dropped())
+		}
+	}
+}
+
+func TestBusBlockWaitsForSubscriber(t *testing.T) {
+	bus := NewBus()
+	s := bus.Subscribe(1, Block)
+	bus.Publish(numbered(1))
+
+	published := make(chan struct{})
+	go func() {
+		bus.Publish(numbered(2))
+		close(published)
+	}()
+	select {
+	case <-published:
+		t.Fatal("publish into a full Block queue did not wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	<-s.Events()
+	<-published
+	bus.Close()
+	if got := drain(s); !equalInts(got, []int{2}) || s.Dropped() != 0 {
+		t.Errorf("received %v with %d dropped, want [2] and none", got, s.Dropped())
+	}
+}
+
+func TestBusCloseReleasesBlockedPublisher(t *testing.T) {
+	bus := NewBus()
+	s := bus.Subscribe(1, Block)
+	bus.Publish(numbered(1))
+	go bus.Publish(numbered(2))
+	time.Sleep(20 * time.Millisecond)
+
+	returns(t, "Bus.Close", bus.Close)
+	if got := drain(s); !equalInts(got, []int{1}) {
+		t.Errorf("received %v, want [1]", got)
+	}
+	returns(t, "Publish after Close", func() { bus.Publish(numbered(3)) })
+}
+
+func TestSubscriptionCloseReleasesBlockedPublisher(t *testing.T) {
+	bus := NewBus()
+	stuck := bus.Subscribe(1, Block)
+	other := bus.Subscribe(8, Block)
+	bus.Publish(numbered(1))
+	go bus.Publish(numbered(2))
+	time.Sleep(20 * time.Millisecond)
+
+	returns(t, "Subscription.Close", stuck.Close)
+	returns(t, "Publish", func() { bus.Publish(numbered(3)) })
+	bus.Close()
+	if got := drain(other); !equalInts(got, []int{1, 2, 3}) {
+		t.Errorf("the other subscriber received %v, want [1 2 3]", got)
+	}
+}
+
+func TestSubscribeClosedBus(t *testing.T) {
+	bus := NewBus()
+	bus.Close()
+	s := bus.Subscribe(1, DropOldest)
+	bus.Publish(numbered(1))
+	if got := drain(s); len(got) != 0 {
+		t.Errorf("received %v from a closed bus", got)
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,89 @@
+package automation
+
+import (
+	"fmt"
+	"image"
+)
+
+// Color probe conditions for Config.ColorCondition.
+const (
+	ColorAny     = "any"
+	ColorCount   = "count"
+	ColorPercent = "percent"
+)
+
+// ColorResult describes how much of the probe rectangle matched the target
+// color. Coverage is a percentage of Total; Box is empty when nothing matched.
+type ColorResult struct {
+	Found    bool            `json:"found"`
+	Matched  int             `json:"matched"`
+	Total    int             `json:"total"`
+	Coverage float64         `json:"coverage"`
+	First    image.Point     `json:"first"`
+	Centroid image.Point     `json:"centroid"`
+	Box      image.Rectangle `json:"box"`
+}
+
+func (c Config) colorRect() image.Rectangle {
+	// Both ends are inclusive and may be given in either order.
+	return image.Rect(min(c.ColorX1, c.ColorX2), min(c.ColorY1, c.ColorY2),
+		max(c.ColorX1, c.ColorX2)+1, max(c.ColorY1, c.ColorY2)+1)
+}
+
+// ColorConditionString renders the color condition for the status log.
+func (c Config) ColorConditionString() string {
+	switch c.ColorCondition {
+	case ColorCount:
+		return fmt.Sprintf("не менее %d пикс.", c.ColorMinPixels)
+	case ColorPercent:
+		return fmt.Sprintf("не менее %.1f%% площади", c.ColorMinPercent)
+	default:
+		return "любой пиксель"
+	}
+}
+
+func scanColor(img image.Image, config Config) ColorResult {
+	rect := config.colorRect().Intersect(img.Bounds())
+	result := ColorResult{Total: rect.Dx() * rect.Dy()}
+	if result.Total == 0 {
+		return result
+	}
+
+	colors := newColorMatcher(config)
+	var sumX, sumY int
+
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			if !colors.match(uint8(r>>8), uint8(g>>8), uint8(b>>8)) {
+				continue
+			}
+
+			if result.Matched == 0 {
+				result.First = image.Point{X: x, Y: y}
+				result.Box = image.Rect(x, y, x+1, y+1)
+			} else {
+				result.Box = result.Box.Union(image.Rect(x, y, x+1, y+1))
+			}
+			result.Matched++
+			sumX += x
+			sumY += y
+		}
+	}
+
+	result.Coverage = float64(result.Matched) * 100 / float64(result.Total)
+	if result.Matched > 0 {
+		result.Centroid = image.Point{X: sumX / result.Matched, Y: sumY / result.Matched}
+	}
+
+	switch config.ColorCondition {
+	case ColorCount:
+		result.Found = result.Matched >= max(config.ColorMinPixels, 1)
+	case ColorPercent:
+		result.Found = result.Matched > 0 && result.Coverage >= config.ColorMinPercent
+	default:
+		result.Found = result.Matched > 0
+	}
+
+	return result
+}
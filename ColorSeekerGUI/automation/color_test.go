@@ -0,0 +1,88 @@
+package automation
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// probeFrame is a black frame with the target color 0x3366cc at the given
+// points, each shifted by the matching offset on every channel.
+func probeFrame(points []image.Point, shift []int) *image.RGBA {
+	frame := image.NewRGBA(image.Rect(0, 0, 12, 10))
+	for i := 3; i < len(frame.Pix); i += 4 {
+		frame.Pix[i] = 255
+	}
+	for i, p := range points {
+		d := shift[i]
+		frame.Set(p.X, p.Y, color.RGBA{R: uint8(0x33 + d), G: uint8(0x66 + d), B: uint8(0xcc + d), A: 255})
+	}
+	return frame
+}
+
+func TestScanColor(t *testing.T) {
+	// Inside the probe (2,2)-(7,6), 30 pixels: four within the shade
+	// variation of 10 and one just beyond; (9,8) is outside.
+	frame := probeFrame(
+		[]image.Point{{5, 3}, {3, 3}, {4, 5}, {7, 6}, {6, 4}, {9, 8}},
+		[]int{0, 10, -10, 3, 11, 0},
+	)
+	config := DefaultConfig()
+	config.TargetColor = 0x3366cc
+	config.ShadeVariation = 10
+	config.ColorX1, config.ColorY1, config.ColorX2, config.ColorY2 = 7, 6, 2, 2
+
+	res := scanColor(frame, config)
+	if res.Matched != 4 || res.Total != 30 || math.Abs(res.Coverage-40.0/3) > 1e-9 {
+		t.Errorf("matched %d of %d (%.2f%%), want 4 of 30", res.Matched, res.Total, res.Coverage)
+	}
+	// First is in scan order; the centroid of (3,3), (5,3), (4,5) and (7,6)
+	// is (4.75, 4.25), truncated.
+	if res.First != image.Pt(3, 3) || res.Centroid != image.Pt(4, 4) || res.Box != image.Rect(3, 3, 8, 7) {
+		t.Errorf("first %v, centroid %v, box %v; want (3,3), (4,4), (3,3)-(8,7)", res.First, res.Centroid, res.Box)
+	}
+
+	for _, tc := range []struct {
+		condition  string
+		minPixels  int
+		minPercent float64
+		want       bool
+	}{
+		{ColorAny, 0, 0, true},
+		{ColorCount, 4, 0, true},
+		{ColorCount, 5, 0, false},
+		{ColorCount, 0, 0, true},
+		{ColorPercent, 0, 13.3, true},
+		{ColorPercent, 0, 13.4, false},
+	} {
+		config.ColorCondition, config.ColorMinPixels, config.ColorMinPercent = tc.condition, tc.minPixels, tc.minPercent
+		if got := scanColor(frame, config).Found; got != tc.want {
+			t.Errorf("%s with %d pixels or %.1f%%: found %v, want %v", tc.condition, tc.minPixels, tc.minPercent, got, tc.want)
+		}
+	}
+}
+
+func TestScanColorNothingMatched(t *testing.T) {
+	frame := probeFrame([]image.Point{{9, 8}}, []int{0})
+	config := DefaultConfig()
+	config.TargetColor = 0x3366cc
+	config.ColorX1, config.ColorY1, config.ColorX2, config.ColorY2 = 0, 0, 5, 5
+
+	// A count of 0 still needs one pixel, a percentage of 0 too.
+	config.ColorMinPixels, config.ColorMinPercent = 0, 0
+	for _, condition := range []string{ColorAny, ColorCount, ColorPercent} {
+		config.ColorCondition = condition
+		res := scanColor(frame, config)
+		if res.Found || res.Matched != 0 || res.Coverage != 0 || !res.Box.Empty() || res.Centroid != (image.Point{}) {
+			t.Errorf("%s: %+v, want nothing found", condition, res)
+		}
+	}
+
+	// The part of the probe outside the frame is not counted.
+	config.ColorCondition = ColorAny
+	config.ColorX1, config.ColorY1, config.ColorX2, config.ColorY2 = 9, 8, 20, 20
+	if res := scanColor(frame, config); !res.Found || res.Matched != 1 || res.Total != 6 {
+		t.Errorf("probe past the edge: %+v, want 1 of 6 pixels", res)
+	}
+}
@@ -0,0 +1,239 @@
+package automation
+
+import (
+	"fmt"
+	"math"
+)
+
+// Color distance models for Config.ColorModel. Each model has its own
+// tolerance fields:
+//
+//   - ColorModelRGB: every channel within ±ShadeVariation of TargetColor.
+//   - ColorModelEuclidean: RGB distance to TargetColor at most ColorDistance.
+//   - ColorModelCIE76, ColorModelCIEDE2000: ΔE to TargetColor in CIE Lab at
+//     most DeltaE (about 2.3 is a just noticeable difference).
+//   - ColorModelHSV: hue, saturation and value inside the HueMin..HueMax
+//     (degrees, wrapping past 360 when HueMin > HueMax), SatMin..SatMax and
+//     ValMin..ValMax (percent) ranges. TargetColor is not used.
+const (
+	ColorModelRGB       = "rgb"
+	ColorModelEuclidean = "euclidean"
+	ColorModelCIE76     = "cie76"
+	ColorModelCIEDE2000 = "ciede2000"
+	ColorModelHSV       = "hsv"
+)
+
+type lab struct {
+	L, A, B float64
+}
+
+type colorMatcher struct {
+	config Config
+	target [3]uint8
+	lab    lab
+	cache  map[uint32]bool
+}
+
+func newColorMatcher(config Config) *colorMatcher {
+	r, g, b := hexToRGB(config.TargetColor)
+	return &colorMatcher{
+		config: config,
+		target: [3]uint8{r, g, b},
+		lab:    rgbToLab(r, g, b),
+		cache:  make(map[uint32]bool),
+	}
+}
+
+func (m *colorMatcher) match(r, g, b uint8) bool {
+	key := uint32(r)<<16 | uint32(g)<<8 | uint32(b)
+	if ok, cached := m.cache[key]; cached {
+		return ok
+	}
+
+	ok := m.compute(r, g, b)
+	m.cache[key] = ok
+	return ok
+}
+
+func (m *colorMatcher) compute(r, g, b uint8) bool {
+	c := m.config
+	switch c.ColorModel {
+	case ColorModelEuclidean:
+		dr := float64(r) - float64(m.target[0])
+		dg := float64(g) - float64(m.target[1])
+		db := float64(b) - float64(m.target[2])
+		return math.Sqrt(dr*dr+dg*dg+db*db) <= c.ColorDistance
+	case ColorModelCIE76:
+		return deltaE76(rgbToLab(r, g, b), m.lab) <= c.DeltaE
+	case ColorModelCIEDE2000:
+		return deltaE2000(rgbToLab(r, g, b), m.lab) <= c.DeltaE
+	case ColorModelHSV:
+		h, s, v := rgbToHSV(r, g, b)
+		return inHueRange(h, c.HueMin, c.HueMax) &&
+			s >= c.SatMin && s <= c.SatMax &&
+			v >= c.ValMin && v <= c.ValMax
+	default:
+		return colorMatch(r, g, b, m.target[0], m.target[1], m.target[2], c.ShadeVariation)
+	}
+}
+
+// ColorModelString renders the color model and its tolerance for the status log.
+func (c Config) ColorModelString() string {
+	switch c.ColorModel {
+	case ColorModelEuclidean:
+		return fmt.Sprintf("RGB евклидово расстояние ≤ %.1f", c.ColorDistance)
+	case ColorModelCIE76:
+		return fmt.Sprintf("CIE76 ΔE ≤ %.1f", c.DeltaE)
+	case ColorModelCIEDE2000:
+		return fmt.Sprintf("CIEDE2000 ΔE ≤ %.1f", c.DeltaE)
+	case ColorModelHSV:
+		return fmt.Sprintf("HSV H=%.0f-%.0f°, S=%.0f-%.0f%%, V=%.0f-%.0f%%",
+			c.HueMin, c.HueMax, c.SatMin, c.SatMax, c.ValMin, c.ValMax)
+	default:
+		return fmt.Sprintf("RGB по каналам ±%d", c.ShadeVariation)
+	}
+}
+
+func inHueRange(h, lo, hi float64) bool {
+	if lo <= hi {
+		return h >= lo && h <= hi
+	}
+	return h >= lo || h <= hi
+}
+
+// rgbToHSV returns hue in degrees and saturation/value in percent.
+func rgbToHSV(r, g, b uint8) (float64, float64, float64) {
+	rf, gf, bf := float64(r)/255, float64(g)/255, float64(b)/255
+	maxC := math.Max(rf, math.Max(gf, bf))
+	minC := math.Min(rf, math.Min(gf, bf))
+	delta := maxC - minC
+
+	var h float64
+	switch {
+	case delta == 0:
+		h = 0
+	case maxC == rf:
+		h = 60 * math.Mod((gf-bf)/delta, 6)
+	case maxC == gf:
+		h = 60 * ((bf-rf)/delta + 2)
+	default:
+		h = 60 * ((rf-gf)/delta + 4)
+	}
+	if h < 0 {
+		h += 360
+	}
+
+	var s float64
+	if maxC > 0 {
+		s = delta / maxC
+	}
+
+	return h, s * 100, maxC * 100
+}
+
+// rgbToLab converts sRGB to CIE Lab under the D65 white point.
+func rgbToLab(r, g, b uint8) lab {
+	lin := func(c uint8) float64 {
+		v := float64(c) / 255
+		if v <= 0.04045 {
+			return v / 12.92
+		}
+		return math.Pow((v+0.055)/1.055, 2.4)
+	}
+	rl, gl, bl := lin(r), lin(g), lin(b)
+
+	x := (0.4124564*rl + 0.3575761*gl + 0.1804375*bl) / 0.95047
+	y := 0.2126729*rl + 0.7151522*gl + 0.0721750*bl
+	z := (0.0193339*rl + 0.1191920*gl + 0.9503041*bl) / 1.08883
+
+	f := func(t float64) float64 {
+		const delta = 6.0 / 29.0
+		if t > delta*delta*delta {
+			return math.Cbrt(t)
+		}
+		return t/(3*delta*delta) + 4.0/29.0
+	}
+	fx, fy, fz := f(x), f(y), f(z)
+
+	return lab{L: 116*fy - 16, A: 500 * (fx - fy), B: 200 * (fy - fz)}
+}
+
+func deltaE76(c1, c2 lab) float64 {
+	dl, da, db := c1.L-c2.L, c1.A-c2.A, c1.B-c2.B
+	return math.Sqrt(dl*dl + da*da + db*db)
+}
+
+// deltaE2000 follows Sharma, Wu and Dalal, "The CIEDE2000 color-difference
+// formula", with kL = kC = kH = 1.
+func deltaE2000(c1, c2 lab) float64 {
+	const pow25to7 = 6103515625.0
+	rad := math.Pi / 180
+
+	cBar := (math.Hypot(c1.A, c1.B) + math.Hypot(c2.A, c2.B)) / 2
+	cBar7 := math.Pow(cBar, 7)
+	g := 0.5 * (1 - math.Sqrt(cBar7/(cBar7+pow25to7)))
+
+	a1, a2 := (1+g)*c1.A, (1+g)*c2.A
+	cp1, cp2 := math.Hypot(a1, c1.B), math.Hypot(a2, c2.B)
+
+	hue := func(b, a float64) float64 {
+		if a == 0 && b == 0 {
+			return 0
+		}
+		h := math.Atan2(b, a) / rad
+		if h < 0 {
+			h += 360
+		}
+		return h
+	}
+	hp1, hp2 := hue(c1.B, a1), hue(c2.B, a2)
+
+	dL := c2.L - c1.L
+	dC := cp2 - cp1
+
+	// Hues 180° apart come out of Atan2 and the wrap to 0..360 a rounding
+	// error off, which must not flip them to the other case of the formula.
+	const halfTurn = 180 + 1e-9
+
+	var dh float64
+	if cp1*cp2 != 0 {
+		dh = hp2 - hp1
+		if dh > halfTurn {
+			dh -= 360
+		} else if dh < -halfTurn {
+			dh += 360
+		}
+	}
+	dH := 2 * math.Sqrt(cp1*cp2) * math.Sin(dh/2*rad)
+
+	lBar := (c1.L + c2.L) / 2
+	cpBar := (cp1 + cp2) / 2
+
+	hBar := hp1 + hp2
+	if cp1*cp2 != 0 {
+		switch {
+		case math.Abs(hp1-hp2) <= halfTurn:
+			hBar /= 2
+		case hp1+hp2 < 360:
+			hBar = (hBar + 360) / 2
+		default:
+			hBar = (hBar - 360) / 2
+		}
+	}
+
+	t := 1 - 0.17*math.Cos((hBar-30)*rad) +
+		0.24*math.Cos(2*hBar*rad) +
+		0.32*math.Cos((3*hBar+6)*rad) -
+		0.20*math.Cos((4*hBar-63)*rad)
+
+	dTheta := 30 * math.Exp(-math.Pow((hBar-275)/25, 2))
+	cpBar7 := math.Pow(cpBar, 7)
+	rc := 2 * math.Sqrt(cpBar7/(cpBar7+pow25to7))
+	l50 := (lBar - 50) * (lBar - 50)
+	sl := 1 + 0.015*l50/math.Sqrt(20+l50)
+	sc := 1 + 0.045*cpBar
+	sh := 1 + 0.015*cpBar*t
+	rt := -math.Sin(2*dTheta*rad) * rc
+
+	return math.Sqrt(math.Pow(dL/sl, 2) + math.Pow(dC/sc, 2) + math.Pow(dH/sh, 2) + rt*(dC/sc)*(dH/sh))
+}
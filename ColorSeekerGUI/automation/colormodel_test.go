@@ -0,0 +1,150 @@
+package automation
+
+import (
+	"math"
+	"testing"
+)
+
+// sharmaPairs is the test data of Sharma, Wu and Dalal for CIEDE2000: two Lab
+// colors and their ΔE00, rounded to four decimals.
+var sharmaPairs = []struct {
+	c1, c2 lab
+	want   float64
+}{
+	{lab{50.0000, 2.6772, -79.7751}, lab{50.0000, 0.0000, -82.7485}, 2.0425},
+	{lab{50.0000, 3.1571, -77.2803}, lab{50.0000, 0.0000, -82.7485}, 2.8615},
+	{lab{50.0000, 2.8361, -74.0200}, lab{50.0000, 0.0000, -82.7485}, 3.4412},
+	{lab{50.0000, -1.3802, -84.2814}, lab{50.0000, 0.0000, -82.7485}, 1.0000},
+	{lab{50.0000, -1.1848, -84.8006}, lab{50.0000, 0.0000, -82.7485}, 1.0000},
+	{lab{50.0000, -0.9009, -85.5211}, lab{50.0000, 0.0000, -82.7485}, 1.0000},
+	{lab{50.0000, 0.0000, 0.0000}, lab{50.0000, -1.0000, 2.0000}, 2.3669},
+	{lab{50.0000, -1.0000, 2.0000}, lab{50.0000, 0.0000, 0.0000}, 2.3669},
+	{lab{50.0000, 2.4900, -0.0010}, lab{50.0000, -2.4900, 0.0009}, 7.1792},
+	{lab{50.0000, 2.4900, -0.0010}, lab{50.0000, -2.4900, 0.0010}, 7.1792},
+	{lab{50.0000, 2.4900, -0.0010}, lab{50.0000, -2.4900, 0.0011}, 7.2195},
+	{lab{50.0000, 2.4900, -0.0010}, lab{50.0000, -2.4900, 0.0012}, 7.2195},
+	{lab{50.0000, -0.0010, 2.4900}, lab{50.0000, 0.0009, -2.4900}, 4.8045},
+	{lab{50.0000, -0.0010, 2.4900}, lab{50.0000, 0.0010, -2.4900}, 4.8045},
+	{lab{50.0000, -0.0010, 2.4900}, lab{50.0000, 0.0011, -2.4900}, 4.7461},
+	{lab{50.0000, 2.5000, 0.0000}, lab{50.0000, 0.0000, -2.5000}, 4.3065},
+	{lab{50.0000, 2.5000, 0.0000}, lab{73.0000, 25.0000, -18.0000}, 27.1492},
+	{lab{50.0000, 2.5000, 0.0000}, lab{61.0000, -5.0000, 29.0000}, 22.8977},
+	{lab{50.0000, 2.5000, 0.0000}, lab{56.0000, -27.0000, -3.0000}, 31.9030},
+	{lab{50.0000, 2.5000, 0.0000}, lab{58.0000, 24.0000, 15.0000}, 19.4535},
+	{lab{50.0000, 2.5000, 0.0000}, lab{50.0000, 3.1736, 0.5854}, 1.0000},
+	{lab{50.0000, 2.5000, 0.0000}, lab{50.0000, 3.2972, 0.0000}, 1.0000},
+	{lab{50.0000, 2.5000, 0.0000}, lab{50.0000, 1.8634, 0.5757}, 1.0000},
+	{lab{50.0000, 2.5000, 0.0000}, lab{50.0000, 3.2592, 0.3350}, 1.0000},
+	{lab{60.2574, -34.0099, 36.2677}, lab{60.4626, -34.1751, 39.4387}, 1.2644},
+	{lab{63.0109, -31.0961, -5.8663}, lab{62.8187, -29.7946, -4.0864}, 1.2630},
+	{lab{61.2901, 3.7196, -5.3901}, lab{61.4292, 2.2480, -4.9620}, 1.8731},
+	{lab{35.0831, -44.1164, 3.7933}, lab{35.0232, -40.0716, 1.5901}, 1.8645},
+	{lab{22.7233, 20.0904, -46.6940}, lab{23.0331, 14.9730, -42.5619}, 2.0373},
+	{lab{36.4612, 47.8580, 18.3852}, lab{36.2715, 50.5065, 21.2231}, 1.4146},
+	{lab{90.8027, -2.0831, 1.4410}, lab{91.1528, -1.6435, 0.0447}, 1.4441},
+	{lab{90.9257, -0.5406, -0.9208}, lab{88.6381, -0.8985, -0.7239}, 1.5381},
+	{lab{6.7747, -0.2908, -2.4247}, lab{5.8714, -0.0985, -2.2286}, 0.6377},
+	{lab{2.0776, 0.0795, -1.1350}, lab{0.9033, -0.0636, -0.5514}, 0.9082},
+}
+
+func TestDeltaE2000(t *testing.T) {
+	for i, tc := range sharmaPairs {
+		// The formula is symmetric, which the pairs with a hue near 180°
+		// apart check on the mean hue.
+		for _, got := range []float64{deltaE2000(tc.c1, tc.c2), deltaE2000(tc.c2, tc.c1)} {
+			if math.Abs(got-tc.want) > 5e-5 {
+				t.Errorf("pair %d: ΔE00(%v, %v) = %.4f, want %.4f", i+1, tc.c1, tc.c2, got, tc.want)
+			}
+		}
+	}
+	if got := deltaE2000(lab{50, 10, -10}, lab{50, 10, -10}); got != 0 {
+		t.Errorf("ΔE00 of equal colors = %v, want 0", got)
+	}
+}
+
+func TestRGBToLab(t *testing.T) {
+	for _, tc := range []struct {
+		r, g, b uint8
+		want    lab
+	}{
+		{0, 0, 0, lab{0, 0, 0}},
+		{255, 255, 255, lab{100, 0, 0}},
+		{128, 128, 128, lab{53.5850, 0, 0}},
+		{255, 0, 0, lab{53.2408, 80.0925, 67.2032}},
+		{0, 255, 0, lab{87.7347, -86.1827, 83.1793}},
+		{0, 0, 255, lab{32.2970, 79.1875, -107.8602}},
+	} {
+		got := rgbToLab(tc.r, tc.g, tc.b)
+		if deltaE76(got, tc.want) > 0.001 {
+			t.Errorf("rgbToLab(%d, %d, %d) = %.4f, want %.4f", tc.r, tc.g, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestRGBToHSV(t *testing.T) {
+	for _, tc := range []struct {
+		r, g, b uint8
+		h, s, v float64
+	}{
+		{0, 0, 0, 0, 0, 0},
+		{255, 255, 255, 0, 0, 100},
+		{255, 0, 0, 0, 100, 100},
+		{0, 255, 0, 120, 100, 100},
+		{0, 0, 255, 240, 100, 100},
+		{255, 255, 0, 60, 100, 100},
+		{0, 128, 128, 180, 100, 50.196},
+		// Red with a little blue lies just below 360°, not below 0°.
+		{255, 0, 51, 348, 100, 100},
+		{204, 102, 153, 330, 50, 80},
+	} {
+		h, s, v := rgbToHSV(tc.r, tc.g, tc.b)
+		if math.Abs(h-tc.h) > 0.01 || math.Abs(s-tc.s) > 0.01 || math.Abs(v-tc.v) > 0.01 {
+			t.Errorf("rgbToHSV(%d, %d, %d) = %.3f, %.3f, %.3f; want %.3f, %.3f, %.3f",
+				tc.r, tc.g, tc.b, h, s, v, tc.h, tc.s, tc.v)
+		}
+	}
+}
+
+func TestHSVHueWrapsAround(t *testing.T) {
+	// 340..20 is the range of reds on both sides of 0°.
+	config := DefaultConfig()
+	config.ColorModel = ColorModelHSV
+	config.HueMin, config.HueMax = 340, 20
+	config.SatMin, config.SatMax = 50, 100
+	config.ValMin, config.ValMax = 50, 100
+	colors := newColorMatcher(config)
+
+	for _, tc := range []struct {
+		r, g, b uint8
+		want    bool
+	}{
+		{255, 0, 0, true},      // 0°
+		{255, 0, 51, true},     // 348°
+		{255, 51, 0, true},     // 12°
+		{255, 0, 128, false},   // 330°
+		{255, 128, 0, false},   // 30°
+		{0, 255, 255, false},   // 180°
+		{128, 100, 100, false}, // red, but too little saturation
+	} {
+		if got := colors.match(tc.r, tc.g, tc.b); got != tc.want {
+			t.Errorf("match(%d, %d, %d) = %v, want %v", tc.r, tc.g, tc.b, got, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		h, lo, hi float64
+		want      bool
+	}{
+		{0, 340, 20, true},
+		{359.9, 340, 20, true},
+		{340, 340, 20, true},
+		{20, 340, 20, true},
+		{180, 340, 20, false},
+		{180, 20, 340, true},
+		{0, 20, 340, false},
+	} {
+		if got := inHueRange(tc.h, tc.lo, tc.hi); got != tc.want {
+			t.Errorf("inHueRange(%v, %v, %v) = %v, want %v", tc.h, tc.lo, tc.hi, got, tc.want)
+		}
+	}
+}
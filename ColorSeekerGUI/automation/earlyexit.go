@@ -0,0 +1,136 @@
+package automation
+
+import "sort"
+
+// Pixel visiting orders for Config.MatchOrder with early exit. Both compare
+// the template a row at a time and check the partial score after every row.
+//
+//   - OrderRaster: top to bottom, as the template is stored.
+//   - OrderContrast: the rows that differ most from the template's mean
+//     color first. On a wrong spot they produce the large differences
+//     soonest, so a hopeless candidate is given up after fewer rows. Whole
+//     rows rather than single pixels keep the inner loop on contiguous
+//     bytes: picking pixels one by one costs more than it saves.
+const (
+	OrderRaster   = "raster"
+	OrderContrast = "contrast"
+)
+
+// MatchStats counts the work of a template search. Candidates is the number
+// of positions scored, Abandoned how many of them early exit gave up before
+// the last pixel, Pixels the template pixels actually compared and Skipped
+// those early exit saved. Without early exit Abandoned and Skipped are 0.
+type MatchStats struct {
+	Candidates int   `json:"candidates"`
+	Abandoned  int   `json:"abandoned"`
+	Pixels     int64 `json:"pixels"`
+	Skipped    int64 `json:"skipped"`
+}
+
+func (s *MatchStats) add(o MatchStats) {
+	s.Candidates += o.Candidates
+	s.Abandoned += o.Abandoned
+	s.Pixels += o.Pixels
+	s.Skipped += o.Skipped
+}
+
+// limit is what a candidate has to achieve to matter: a score above best,
+// and not below threshold.
+type limit struct {
+	best      float64
+	threshold float64
+}
+
+func (l limit) hopeless(score float64) bool {
+	return score <= l.best || score < l.threshold
+}
+
+// earlyExit reports whether the matcher abandons candidates early. NCC is
+// not a running sum that can only fall, so it always compares every pixel.
+func (m *matcher) earlyExit() bool {
+	return m.early && m.metric != MetricNCC
+}
+
+// contrastRows lists the template rows in contrast order. Rows of a
+// template are few, so it is cheap enough to build for every search.
+func contrastRows(t *templateImage) []int {
+	size := t.Size()
+	n := int64(t.count)
+	rows := make([]int, size.Y)
+	contrast := make([]int64, size.Y)
+	for y := 0; y < size.Y; y++ {
+		rows[y] = y
+		for x := 0; x < size.X; x++ {
+			if !t.counts(x, y) {
+				continue
+			}
+			// The distance from the mean color, times n to stay in integers.
+			r, g, b := t.rgb(x, y)
+			for c, v := range [3]int{r, g, b} {
+				d := int64(v)*n - t.sum[c]
+				if d < 0 {
+					d = -d
+				}
+				contrast[y] += d
+			}
+		}
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		return contrast[rows[i]] > contrast[rows[j]]
+	})
+	return rows
+}
+
+// compareEarly scores template at x, y like compareRegion, but stops as soon
+// as the partial score shows the candidate is hopeless for lim. SAD and SSD
+// only fall as pixels are added, so a candidate given up could not have
+// scored better at the end. It reports false for an abandoned candidate.
+// Rows are compared in the given order, nil for top to bottom.
+func (m *matcher) compareEarly(img *pixels, t *templateImage, rows []int, x, y int, lim limit, stats *MatchStats) (float64, bool) {
+	maxDiff := float64(t.count * 255 * 3)
+	if m.metric == MetricSSD {
+		maxDiff = float64(t.count*3) * 255 * 255
+	}
+	stats.Candidates++
+
+	size := t.Size()
+	var total, done int
+	for i := 0; i < size.Y; i++ {
+		ty := i
+		if rows != nil {
+			ty = rows[i]
+		}
+		row := img.row(x, y+ty, size.X)
+		trow, mask := t.row(ty)
+		if mask == nil {
+			total += m.rowDiff(row, trow)
+			done += size.X
+		} else {
+			for tx, j := 0, 0; j < len(trow); tx, j = tx+1, j+4 {
+				if mask[tx] {
+					total += m.rowDiff(row[j:j+4], trow[j:j+4])
+					done++
+				}
+			}
+		}
+
+		score := 1.0 - float64(total)/maxDiff
+		if done < t.count && lim.hopeless(score) {
+			stats.Abandoned++
+			stats.Pixels += int64(done)
+			stats.Skipped += int64(t.count - done)
+			return score, false
+		}
+	}
+
+	stats.Pixels += int64(t.count)
+	return 1.0 - float64(total)/maxDiff, true
+}
+
+func (m *matcher) rowDiff(row, trow []uint8) int {
+	if m.metric == MetricSSD {
+		return ssdRow(row, trow)
+	}
+	return sadRow(row, trow)
+}
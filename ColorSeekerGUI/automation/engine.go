@@ -0,0 +1,222 @@
+package automation
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// State is the lifecycle state of an Engine.
+//
+//	idle → running ⇄ paused
+//	running, paused → stopping → stopped
+//	running → failed (the loop panicked)
+//
+// A stopped or failed engine can be started again.
+type State string
+
+const (
+	StateIdle     State = "idle"
+	StateRunning  State = "running"
+	StatePaused   State = "paused"
+	StateStopping State = "stopping"
+	StateStopped  State = "stopped"
+	StateFailed   State = "failed"
+)
+
+// Active reports whether the loop goroutine is alive in this state.
+func (s State) Active() bool {
+	return s == StateRunning || s == StatePaused || s == StateStopping
+}
+
+// Engine owns one automation loop and its state. All methods are safe for
+// concurrent use.
+type Engine struct {
+	bus  *Bus
+	opts []Option
+
+	mu     sync.Mutex
+	config Config
+	state  State
+	err    error
+	cancel context.CancelFunc
+	done   chan error
+	resume chan struct{}
+}
+
+// NewEngine creates an idle engine that publishes its events on bus.
+func NewEngine(config Config, bus *Bus, opts ...Option) *Engine {
+	return &Engine{
+		bus:    bus,
+		opts:   opts,
+		config: config,
+		state:  StateIdle,
+	}
+}
+
+// Bus returns the bus the engine publishes on.
+func (e *Engine) Bus() *Bus {
+	return e.bus
+}
+
+// State returns the current state.
+func (e *Engine) State() State {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.state
+}
+
+// Err returns the error the last run ended with, if it failed.
+func (e *Engine) Err() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.err
+}
+
+// Config returns the configuration used by the next Start.
+func (e *Engine) Config() Config {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.config
+}
+
+// SetConfig replaces the configuration. A running loop keeps the one it was
+// started with; the new one is used from the next Start.
+func (e *Engine) SetConfig(config Config) {
+	e.mu.Lock()
+	e.config = config
+	e.mu.Unlock()
+}
+
+// Done returns a channel of the current run. When the loop ends it receives
+// the terminal error (nil after a normal stop) and is then closed, so only one
+// receiver gets the value; the others can read Err. Before the first Start
+// the channel is nil.
+func (e *Engine) Done() <-chan error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.done
+}
+
+// Start launches the loop. Cancelling ctx stops it just like Stop does.
+func (e *Engine) Start(ctx context.Context) error {
+	e.mu.Lock()
+	if e.state.Active() {
+		state := e.state
+		e.mu.Unlock()
+		return fmt.Errorf("автоматизация уже запущена (состояние: %s)", state)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	e.state = StateRunning
+	e.err = nil
+	e.cancel = cancel
+	e.done = done
+	e.resume = nil
+
+	r := newRunner(ctx, e.config, e.bus, e.opts)
+	r.paused = e.paused
+	e.mu.Unlock()
+
+	e.publish(StateRunning)
+	go e.run(r, cancel, done)
+	return nil
+}
+
+func (e *Engine) run(r *runner, cancel context.CancelFunc, done chan error) {
+	var err error
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("сбой автоматизации: %v", p)
+			r.emit(&EngineError{Op: OpPanic, Err: fmt.Sprint(p), Fatal: true})
+		}
+		cancel()
+
+		state := StateStopped
+		if err != nil {
+			state = StateFailed
+		}
+		e.mu.Lock()
+		e.state = state
+		e.err = err
+		e.resume = nil
+		e.mu.Unlock()
+
+		// Published after the state is final, so that an observer reacting to
+		// the event reads the same state from State.
+		e.publish(state)
+		done <- err
+		close(done)
+	}()
+
+	r.loop(r.ctx)
+}
+
+// Stop asks the loop to finish. It returns at once; the state stays stopping
+// until the current step is over, which Done reports.
+func (e *Engine) Stop() {
+	e.mu.Lock()
+	if e.state != StateRunning && e.state != StatePaused {
+		e.mu.Unlock()
+		return
+	}
+	e.state = StateStopping
+	cancel := e.cancel
+	e.mu.Unlock()
+
+	// Cancel only after publishing, so that stopping is never reported after
+	// the stopped of the loop it ends.
+	e.publish(StateStopping)
+	cancel()
+}
+
+// Pause holds the loop before its next iteration.
+func (e *Engine) Pause() error {
+	e.mu.Lock()
+	if e.state != StateRunning {
+		state := e.state
+		e.mu.Unlock()
+		return fmt.Errorf("приостановить можно только запущенную автоматизацию (состояние: %s)", state)
+	}
+	e.state = StatePaused
+	e.resume = make(chan struct{})
+	e.mu.Unlock()
+
+	e.publish(StatePaused)
+	return nil
+}
+
+// Resume continues a paused loop.
+func (e *Engine) Resume() error {
+	e.mu.Lock()
+	if e.state != StatePaused {
+		state := e.state
+		e.mu.Unlock()
+		return fmt.Errorf("продолжить можно только приостановленную автоматизацию (состояние: %s)", state)
+	}
+	e.state = StateRunning
+	close(e.resume)
+	e.resume = nil
+	e.mu.Unlock()
+
+	e.publish(StateRunning)
+	return nil
+}
+
+// publish reports a state change. It is called without the lock held, since
+// a subscriber with the Block policy may call back into the engine.
+func (e *Engine) publish(state State) {
+	if e.bus != nil {
+		e.bus.Publish(&EngineStateChanged{Header: Header{Time: time.Now()}, State: state})
+	}
+}
+
+// paused returns a channel that is closed on Resume, or nil when the engine
+// is not paused.
+func (e *Engine) paused() <-chan struct{} {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.resume
+}
@@ -0,0 +1,169 @@
+package automation
+
+import (
+	"context"
+	"image"
+	"strings"
+	"testing"
+	"time"
+)
+
+// panicScreen stands for a capture backend that crashes.
+type panicScreen struct{}
+
+func (panicScreen) Bounds() image.Rectangle { return image.Rect(0, 0, 400, 300) }
+
+func (panicScreen) Capture(image.Rectangle) (image.Image, error) {
+	panic("захват сломался")
+}
+
+// waitingConfig loops over a rule that only waits, so that the engine idles
+// along without a screen.
+func waitingConfig() Config {
+	config := loopConfig()
+	config.Rules = []Rule{{
+		When:    Condition{Type: CondAlways},
+		Actions: []Action{{Type: ActionWait, DurationMs: 5}},
+	}}
+	return config
+}
+
+// finished waits for the value Done delivers.
+func finished(t *testing.T, e *Engine) error {
+	t.Helper()
+	select {
+	case err := <-e.Done():
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the engine did not finish, state %s", e.State())
+		return nil
+	}
+}
+
+// states returns the engine states published on s, in order.
+func states(s *Subscription) []State {
+	var got []State
+	for e := range s.Events() {
+		if e, ok := e.(*EngineStateChanged); ok {
+			got = append(got, e.State)
+		}
+	}
+	return got
+}
+
+func TestEngineTransitions(t *testing.T) {
+	bus := NewBus()
+	events := bus.Subscribe(4096, DropNewest)
+	engine := NewEngine(waitingConfig(), bus, WithScreen(NewMemoryScreen(nil)), WithActuator(NewRecordingActuator()))
+
+	start := func(e *Engine) error { return e.Start(context.Background()) }
+	stop := func(e *Engine) error { e.Stop(); return nil }
+	for _, step := range []struct {
+		name  string
+		op    func(*Engine) error
+		want  State // "" when the state is racing to the next one
+		fails bool
+	}{
+		{"resume idle", (*Engine).Resume, StateIdle, true},
+		{"pause idle", (*Engine).Pause, StateIdle, true},
+		{"start", start, StateRunning, false},
+		{"start running", start, StateRunning, true},
+		{"resume running", (*Engine).Resume, StateRunning, true},
+		{"pause", (*Engine).Pause, StatePaused, false},
+		{"pause paused", (*Engine).Pause, StatePaused, true},
+		{"resume", (*Engine).Resume, StateRunning, false},
+		{"pause again", (*Engine).Pause, StatePaused, false},
+		{"stop paused", stop, "", false},
+	} {
+		err := step.op(engine)
+		if (err != nil) != step.fails {
+			t.Errorf("%s: error %v, want failure %v", step.name, err, step.fails)
+		}
+		if got := engine.State(); step.want != "" && got != step.want {
+			t.Errorf("%s: state %s, want %s", step.name, got, step.want)
+		}
+	}
+
+	if err := finished(t, engine); err != nil {
+		t.Errorf("a stopped run ended with %v", err)
+	}
+	if state := engine.State(); state != StateStopped {
+		t.Errorf("state %s after Done, want %s", state, StateStopped)
+	}
+	if engine.Pause() == nil || engine.Resume() == nil {
+		t.Error("Pause or Resume of a stopped engine succeeded")
+	}
+	bus.Close()
+
+	want := []State{StateRunning, StatePaused, StateRunning, StatePaused, StateStopping, StateStopped}
+	if got := states(events); !equalStates(got, want) {
+		t.Errorf("published %v, want %v", got, want)
+	}
+}
+
+func TestEngineRestarts(t *testing.T) {
+	engine := NewEngine(waitingConfig(), nil, WithScreen(NewMemoryScreen(nil)), WithActuator(NewRecordingActuator()))
+	for run := 1; run <= 2; run++ {
+		if err := engine.Start(context.Background()); err != nil {
+			t.Fatalf("start %d: %v", run, err)
+		}
+		engine.Stop()
+		if err := finished(t, engine); err != nil || engine.State() != StateStopped {
+			t.Errorf("run %d ended with %v in state %s", run, err, engine.State())
+		}
+	}
+}
+
+func TestEnginePanicFails(t *testing.T) {
+	bus := NewBus()
+	events := bus.Subscribe(4096, DropNewest)
+	engine := NewEngine(loopConfig(), bus, WithScreen(panicScreen{}), WithActuator(NewRecordingActuator()))
+	if err := engine.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	done := engine.Done()
+	err := finished(t, engine)
+	if err == nil || !strings.Contains(err.Error(), "захват сломался") {
+		t.Errorf("Done delivered %v, want the panic", err)
+	}
+	if _, open := <-done; open {
+		t.Error("Done was not closed after the error")
+	}
+	if engine.State() != StateFailed || engine.Err() != err {
+		t.Errorf("state %s with %v, want %s with %v", engine.State(), engine.Err(), StateFailed, err)
+	}
+	if engine.Pause() == nil {
+		t.Error("Pause of a failed engine succeeded")
+	}
+	bus.Close()
+
+	var fatal bool
+	var got []State
+	for e := range events.Events() {
+		switch e := e.(type) {
+		case *EngineError:
+			fatal = fatal || e.Op == OpPanic && e.Fatal
+		case *EngineStateChanged:
+			got = append(got, e.State)
+		}
+	}
+	if !fatal {
+		t.Error("no fatal panic error was published")
+	}
+	if want := []State{StateRunning, StateFailed}; !equalStates(got, want) {
+		t.Errorf("published %v, want %v", got, want)
+	}
+}
+
+func equalStates(a, b []State) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
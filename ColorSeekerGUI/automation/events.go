@@ -0,0 +1,217 @@
+package automation
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"time"
+)
+
+// Level is the severity of an event, for coloring and filtering.
+type Level string
+
+const (
+	LevelInfo    Level = "info"
+	LevelSuccess Level = "success"
+	LevelWarning Level = "warning"
+	LevelError   Level = "error"
+)
+
+// Event is something the automation reports. The concrete types are the
+// pointers to the structs below; consumers switch on them and render the
+// fields as they need. Kind is a stable name for logs and filters.
+type Event interface {
+	Kind() string
+	Level() Level
+	header() *Header
+}
+
+// Header is embedded in every event. Iteration is the loop pass the event
+// belongs to, 0 outside of one.
+type Header struct {
+	Time      time.Time `json:"time"`
+	Iteration int       `json:"iteration,omitempty"`
+}
+
+func (h *Header) header() *Header { return h }
+
+// EventHeader returns the common fields of e.
+func EventHeader(e Event) Header {
+	return *e.header()
+}
+
+// EngineStateChanged reports a transition of the engine to State.
+type EngineStateChanged struct {
+	Header
+	State State `json:"state"`
+}
+
+// FrameCaptured reports the one screen capture of an iteration: Rect is the
+// captured area, the union of the regions the rules look at, and Duration the
+// capture latency.
+type FrameCaptured struct {
+	Header
+	Rect     image.Rectangle `json:"rect"`
+	Duration time.Duration   `json:"duration"`
+}
+
+// IterationStarted opens a loop pass.
+type IterationStarted struct {
+	Header
+}
+
+// RuleMatched reports the rule chosen in this iteration; Index counts from 0.
+type RuleMatched struct {
+	Header
+	Index int    `json:"index"`
+	Name  string `json:"name,omitempty"`
+}
+
+// ColorProbeResult is the outcome of the color probe over Rect. Condition,
+// MinPixels and MinPercent repeat the configured condition.
+type ColorProbeResult struct {
+	Header
+	ColorResult
+	Target     uint32          `json:"target"`
+	Rect       image.Rectangle `json:"rect"`
+	Condition  string          `json:"condition"`
+	MinPixels  int             `json:"min_pixels"`
+	MinPercent float64         `json:"min_percent"`
+	Duration   time.Duration   `json:"duration"`
+}
+
+// TemplateMatchResult is the outcome of one template search in Region. Match
+// is the best placement even when it is below Threshold. When All is set the
+// search looked for every occurrence and Matches lists them, best first.
+// Stats tells how much of the comparing early exit saved.
+type TemplateMatchResult struct {
+	Header
+	Path      string          `json:"path"`
+	Region    image.Rectangle `json:"region"`
+	Found     bool            `json:"found"`
+	Match     Match           `json:"match"`
+	All       bool            `json:"all,omitempty"`
+	Matches   []Match         `json:"matches,omitempty"`
+	Threshold float64         `json:"threshold"`
+	Stats     MatchStats      `json:"stats"`
+	Duration  time.Duration   `json:"duration"`
+}
+
+// ActionPerformed reports an executed action. Point is where the mouse acted
+// and To the drop point of a drag; Match is the template hit used, if any.
+type ActionPerformed struct {
+	Header
+	Action Action      `json:"action"`
+	Point  image.Point `json:"point"`
+	To     image.Point `json:"to"`
+	Match  *Match      `json:"match,omitempty"`
+}
+
+// VerifyResult is one post-action check. Final marks the last attempt, after
+// which a failed action is given up; Retry is the pause before the next one.
+// Skipped means no condition could be derived for the action; Err is why
+// the check could not be evaluated, which counts as not passed.
+type VerifyResult struct {
+	Header
+	Action   Action        `json:"action"`
+	Expect   Condition     `json:"expect"`
+	Attempt  int           `json:"attempt"`
+	Attempts int           `json:"attempts"`
+	Passed   bool          `json:"passed"`
+	Final    bool          `json:"final"`
+	Skipped  bool          `json:"skipped,omitempty"`
+	Retry    time.Duration `json:"retry,omitempty"`
+	Err      string        `json:"error,omitempty"`
+}
+
+// Error operations for EngineError.Op.
+const (
+	OpCapture  = "capture"
+	OpTemplate = "template"
+	OpAction   = "action"
+	OpInput    = "input"
+	OpPanic    = "panic"
+)
+
+// EngineError reports a failure. Fatal errors end the run.
+type EngineError struct {
+	Header
+	Op     string  `json:"op"`
+	Path   string  `json:"path,omitempty"`
+	Action *Action `json:"action,omitempty"`
+	Err    string  `json:"error"`
+	Fatal  bool    `json:"fatal,omitempty"`
+}
+
+func (*EngineStateChanged) Kind() string  { return "engine_state_changed" }
+func (*IterationStarted) Kind() string    { return "iteration_started" }
+func (*FrameCaptured) Kind() string       { return "frame_captured" }
+func (*RuleMatched) Kind() string         { return "rule_matched" }
+func (*ColorProbeResult) Kind() string    { return "color_probe_result" }
+func (*TemplateMatchResult) Kind() string { return "template_match_result" }
+func (*ActionPerformed) Kind() string     { return "action_performed" }
+func (*VerifyResult) Kind() string        { return "verify_result" }
+func (*EngineError) Kind() string         { return "engine_error" }
+
+func (*EngineStateChanged) Level() Level { return LevelInfo }
+func (*IterationStarted) Level() Level   { return LevelInfo }
+func (*FrameCaptured) Level() Level      { return LevelInfo }
+func (*RuleMatched) Level() Level        { return LevelInfo }
+func (*ActionPerformed) Level() Level    { return LevelSuccess }
+func (*EngineError) Level() Level        { return LevelError }
+
+func (e *ColorProbeResult) Level() Level {
+	if e.Found {
+		return LevelSuccess
+	}
+	return LevelWarning
+}
+
+func (e *TemplateMatchResult) Level() Level {
+	if e.Found {
+		return LevelSuccess
+	}
+	return LevelWarning
+}
+
+func (e *VerifyResult) Level() Level {
+	switch {
+	case e.Passed:
+		return LevelSuccess
+	case e.Final && !e.Skipped:
+		return LevelError
+	default:
+		return LevelWarning
+	}
+}
+
+// emit stamps e with the time and the current iteration and publishes it.
+func (r *runner) emit(e Event) {
+	h := e.header()
+	if h.Time.IsZero() {
+		h.Time = time.Now()
+	}
+	h.Iteration = r.iteration
+	if r.failure == nil && e.Level() == LevelError {
+		r.failure = failure(e)
+	}
+	if r.bus != nil {
+		r.bus.Publish(e)
+	}
+}
+
+// failure is the error an error-level event stands for.
+func failure(e Event) error {
+	switch e := e.(type) {
+	case *EngineError:
+		return fmt.Errorf("%s: %s", e.Op, e.Err)
+	case *VerifyResult:
+		return fmt.Errorf("действие %s не подтверждено проверкой", e.Action.Type)
+	default:
+		return errors.New(e.Kind())
+	}
+}
+
+func (r *runner) fail(op string, err error) {
+	r.emit(&EngineError{Op: op, Err: err.Error()})
+}
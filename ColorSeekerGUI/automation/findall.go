@@ -0,0 +1,183 @@
+package automation
+
+import (
+	"image"
+	"sort"
+)
+
+// Click targets for Action.Select. First and last follow reading order:
+// hits less than half a template height apart form one row, read left to
+// right. Topmost is simply the hit with the smallest Y.
+const (
+	ClickBest    = "best"
+	ClickFirst   = "first"
+	ClickLast    = "last"
+	ClickNearest = "nearest"
+	ClickTopmost = "topmost"
+	ClickAll     = "all"
+)
+
+// FindAll returns every occurrence of template in screen that scores at least
+// config.MatchThreshold, best score first. Hits overlapping a better one by
+// more than config.MatchOverlap (intersection over union) are suppressed and
+// at most config.MaxMatches are returned.
+func FindAll(screen image.Image, template image.Image, config Config) ([]Match, error) {
+	t, err := prepareTemplate(template, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return newMatcher(config).findAll(screen, t, config.MatchThreshold, config.MaxMatches, config.MatchOverlap)
+}
+
+// findAll fails only when the matcher's context is cancelled.
+func (m *matcher) findAll(screen image.Image, template *templateImage, threshold float64, maxCount int, overlap float64) ([]Match, error) {
+	maxCount = max(maxCount, 1)
+	screens, scaledTemplates := m.prepare(screen, template)
+
+	var matches []Match
+	for i, scale := range m.scales {
+		scaled := scaledTemplates[i]
+		if !fits(scaled, screens[0]) {
+			continue
+		}
+		size := scaled.Size()
+
+		// Every coarse candidate is refined on its own; their number is
+		// bounded so that a noisy screen cannot explode the search.
+		templates := templatePyramid(scaled, screens)
+		points, err := m.pyramidCandidates(screens, templates, max(m.candidates, maxCount*4))
+		if err != nil {
+			return nil, err
+		}
+		radius := refineRadius(0, len(templates)-1)
+		for _, p := range points {
+			refined, err := m.searchAround(screens[0], scaled, p, radius, candidate{loc: p, score: -1.0}, threshold)
+			if err != nil {
+				return nil, err
+			}
+			if refined.score >= threshold {
+				matches = append(matches, Match{Location: refined.loc, Size: size, Scale: scale, Score: refined.score})
+			}
+		}
+	}
+
+	return suppressOverlaps(matches, overlap, maxCount), nil
+}
+
+// suppressOverlaps is greedy non-maximum suppression: matches are taken best
+// first and dropped when they overlap an already kept one by more than overlap.
+func suppressOverlaps(matches []Match, overlap float64, maxCount int) []Match {
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+
+	var kept []Match
+	for _, candidate := range matches {
+		if len(kept) >= maxCount {
+			break
+		}
+
+		suppressed := false
+		for _, k := range kept {
+			if intersectionOverUnion(candidate.Rect(), k.Rect()) > overlap {
+				suppressed = true
+				break
+			}
+		}
+		if !suppressed {
+			kept = append(kept, candidate)
+		}
+	}
+
+	return kept
+}
+
+func intersectionOverUnion(a, b image.Rectangle) float64 {
+	inter := a.Intersect(b)
+	if inter.Empty() {
+		return 0
+	}
+
+	i := inter.Dx() * inter.Dy()
+	u := a.Dx()*a.Dy() + b.Dx()*b.Dy() - i
+	return float64(i) / float64(u)
+}
+
+func (m Match) Rect() image.Rectangle {
+	return image.Rectangle{Min: m.Location, Max: m.Location.Add(m.Size)}
+}
+
+func (m Match) Center() image.Point {
+	return image.Point{X: m.Location.X + m.Size.X/2, Y: m.Location.Y + m.Size.Y/2}
+}
+
+// selectMatches picks the matches to click according to target. matches must
+// be sorted best first, as returned by findAll.
+func selectMatches(matches []Match, target string, near image.Point) []Match {
+	if len(matches) == 0 {
+		return nil
+	}
+
+	ordered := readingOrder(matches)
+	switch target {
+	case ClickFirst:
+		return ordered[:1]
+	case ClickTopmost:
+		top := matches[0]
+		for _, match := range matches[1:] {
+			if match.Location.Y < top.Location.Y || (match.Location.Y == top.Location.Y && match.Location.X < top.Location.X) {
+				top = match
+			}
+		}
+		return []Match{top}
+	case ClickLast:
+		return ordered[len(ordered)-1:]
+	case ClickAll:
+		return ordered
+	case ClickNearest:
+		nearest := matches[0]
+		for _, match := range matches[1:] {
+			if distanceSq(match.Center(), near) < distanceSq(nearest.Center(), near) {
+				nearest = match
+			}
+		}
+		return []Match{nearest}
+	default:
+		return matches[:1]
+	}
+}
+
+// readingOrder sorts matches into rows, top to bottom, and each row left to
+// right. A match starts a new row when it lies half a match height or more
+// below the top of the current one. Comparing two matches directly would
+// not be transitive, and the order would depend on the input.
+func readingOrder(matches []Match) []Match {
+	ordered := append([]Match(nil), matches...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		a, b := ordered[i].Location, ordered[j].Location
+		if a.Y != b.Y {
+			return a.Y < b.Y
+		}
+		return a.X < b.X
+	})
+
+	start := 0
+	for i := 1; i <= len(ordered); i++ {
+		top := ordered[start]
+		if i < len(ordered) && ordered[i].Location.Y-top.Location.Y < max(top.Size.Y, ordered[i].Size.Y)/2 {
+			continue
+		}
+		row := ordered[start:i]
+		sort.SliceStable(row, func(a, b int) bool {
+			return row[a].Location.X < row[b].Location.X
+		})
+		start = i
+	}
+	return ordered
+}
+
+func distanceSq(a, b image.Point) int {
+	d := a.Sub(b)
+	return d.X*d.X + d.Y*d.Y
+}
@@ -0,0 +1,128 @@
+package automation
+
+import (
+	"errors"
+	"image"
+	"time"
+)
+
+var errNoScreen = errors.New("экран не найден")
+
+// frame is the one capture an iteration evaluates all its conditions and
+// actions against, so that the color probe and the template searches see the
+// same moment. It covers only area, the union of the regions the iteration
+// may look at, and is taken on first use.
+type frame struct {
+	area     image.Rectangle
+	img      image.Image
+	err      error
+	captured bool
+}
+
+// frameArea collects the screen regions that conditions and actions read,
+// clipped to the display.
+type frameArea struct {
+	config Config
+	bounds image.Rectangle
+	rect   image.Rectangle
+}
+
+func (a *frameArea) add(rect image.Rectangle) {
+	a.rect = a.rect.Union(rect.Intersect(a.bounds))
+}
+
+func (a *frameArea) template(ref *TemplateRef) {
+	if ref != nil {
+		a.add(ref.Region.Resolve(a.bounds))
+	}
+}
+
+func (a *frameArea) condition(c Condition) {
+	switch c.Type {
+	case CondColor:
+		a.add(a.config.colorRect())
+	case CondTemplate, CondTemplateAbsent:
+		a.template(c.Template)
+	}
+	for _, sub := range c.Conditions {
+		a.condition(sub)
+	}
+}
+
+func (a *frameArea) action(action Action) {
+	a.template(action.Template)
+	a.template(action.To)
+}
+
+// newIteration starts a loop pass over the rules. Only the first matching
+// rule runs, but which one is known only after the capture, so the frame
+// covers all of them.
+func (r *runner) newIteration() *iteration {
+	area := r.frameArea()
+	for _, rule := range r.config.Rules {
+		area.condition(rule.When)
+		for _, action := range rule.Actions {
+			area.action(action)
+		}
+	}
+	return newIteration(area.rect)
+}
+
+// checkIteration is a pass that evaluates just c, as a verify check does.
+func (r *runner) checkIteration(c Condition) *iteration {
+	area := r.frameArea()
+	area.condition(c)
+	return newIteration(area.rect)
+}
+
+// actionIteration is a pass that performs just action, as a verify retry does.
+func (r *runner) actionIteration(action Action) *iteration {
+	area := r.frameArea()
+	area.action(action)
+	return newIteration(area.rect)
+}
+
+func (r *runner) frameArea() *frameArea {
+	return &frameArea{config: r.config, bounds: r.screen.Bounds()}
+}
+
+// frame captures the iteration's frame once and returns it.
+func (r *runner) frame(it *iteration) (image.Image, error) {
+	f := &it.frame
+	if f.captured {
+		return f.img, f.err
+	}
+	f.captured = true
+	bounds := r.screen.Bounds()
+	if f.area.Empty() && !bounds.Empty() {
+		// Nothing the iteration looks at is on screen; the probes find
+		// nothing in an empty frame without a capture.
+		f.img = image.NewRGBA(f.area)
+		return f.img, nil
+	}
+
+	// Without a screen at all, as for a display that is not connected, the
+	// capture is still tried: the source knows best what is wrong.
+	start := time.Now()
+	f.img, f.err = r.screen.Capture(f.area)
+	if f.err == nil && bounds.Empty() {
+		f.img, f.err = nil, errNoScreen
+	}
+	if f.err != nil {
+		r.fail(OpCapture, f.err)
+		return nil, f.err
+	}
+	r.emit(&FrameCaptured{Rect: f.area, Duration: time.Since(start)})
+	return f.img, nil
+}
+
+// subImage returns the part of img inside rect, sharing the pixels when the
+// image type allows it.
+func subImage(img image.Image, rect image.Rectangle) image.Image {
+	if sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(rect)
+	}
+	return cropFrame(img, rect)
+}
@@ -0,0 +1,155 @@
+package automation
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/vcaesar/keycode"
+)
+
+// Actuator performs mouse and keyboard input on behalf of the automation loop.
+// Buttons use robotgo names: "left", "center", "right". Points are in the
+// coordinates of the ScreenSource, that is of the captured frames.
+type Actuator interface {
+	Move(x, y int)
+	Click(button string, double bool)
+	MouseToggle(button string, down bool)
+	Press(key string, modifiers ...string) error
+	KeyToggle(key string, down bool) error
+	Type(text string)
+	Scroll(dx, dy int)
+}
+
+// unavailable is implemented by an Actuator that cannot drive any input,
+// like RobotActuator in a headless build; actions that need input fail with
+// its error.
+type unavailable interface {
+	Unavailable() error
+}
+
+// shifted maps an uppercase letter to its lowercase key and a shifted symbol
+// to the key it is on, adding shift to the modifiers for both.
+func shifted(key string, modifiers []string) (string, []string) {
+	shift := false
+	if r := []rune(key); len(r) == 1 && unicode.IsUpper(r[0]) {
+		key, shift = strings.ToLower(key), true
+	}
+	if base, ok := keycode.Special[key]; ok {
+		key, shift = base, true
+	}
+
+	modifiers = append([]string(nil), modifiers...)
+	if shift && !hasModifier(modifiers, "shift") {
+		modifiers = append(modifiers, "shift")
+	}
+	return key, modifiers
+}
+
+func hasModifier(modifiers []string, name string) bool {
+	for _, m := range modifiers {
+		if strings.EqualFold(m, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// RecordedAction is one entry of the RecordingActuator log. X and Y hold the
+// cursor position at the time of the action.
+type RecordedAction struct {
+	Op        string   `json:"op"`
+	X         int      `json:"x"`
+	Y         int      `json:"y"`
+	Button    string   `json:"button,omitempty"`
+	Double    bool     `json:"double,omitempty"`
+	Key       string   `json:"key,omitempty"`
+	Modifiers []string `json:"modifiers,omitempty"`
+	Text      string   `json:"text,omitempty"`
+	DX        int      `json:"dx,omitempty"`
+	DY        int      `json:"dy,omitempty"`
+}
+
+// RecordingActuator never touches the real input devices; it only keeps an
+// ordered log of what would have been done.
+type RecordingActuator struct {
+	mu      sync.Mutex
+	x, y    int
+	actions []RecordedAction
+}
+
+func NewRecordingActuator() *RecordingActuator {
+	return &RecordingActuator{}
+}
+
+func (a *RecordingActuator) record(action RecordedAction) {
+	a.mu.Lock()
+	action.X, action.Y = a.x, a.y
+	a.actions = append(a.actions, action)
+	a.mu.Unlock()
+}
+
+func (a *RecordingActuator) Move(x, y int) {
+	a.mu.Lock()
+	a.x, a.y = x, y
+	a.mu.Unlock()
+	a.record(RecordedAction{Op: "move"})
+}
+
+func (a *RecordingActuator) Click(button string, double bool) {
+	a.record(RecordedAction{Op: "click", Button: button, Double: double})
+}
+
+func (a *RecordingActuator) MouseToggle(button string, down bool) {
+	if down {
+		a.record(RecordedAction{Op: "mouse_down", Button: button})
+	} else {
+		a.record(RecordedAction{Op: "mouse_up", Button: button})
+	}
+}
+
+func (a *RecordingActuator) Press(key string, modifiers ...string) error {
+	a.record(RecordedAction{Op: "press", Key: key, Modifiers: append([]string(nil), modifiers...)})
+	return nil
+}
+
+func (a *RecordingActuator) KeyToggle(key string, down bool) error {
+	if down {
+		a.record(RecordedAction{Op: "key_down", Key: key})
+	} else {
+		a.record(RecordedAction{Op: "key_up", Key: key})
+	}
+	return nil
+}
+
+func (a *RecordingActuator) Type(text string) {
+	a.record(RecordedAction{Op: "type", Text: text})
+}
+
+func (a *RecordingActuator) Scroll(dx, dy int) {
+	a.record(RecordedAction{Op: "scroll", DX: dx, DY: dy})
+}
+
+// Actions returns a copy of the log in the order the actions were made.
+func (a *RecordingActuator) Actions() []RecordedAction {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]RecordedAction(nil), a.actions...)
+}
+
+// Clicks returns only the click entries of the log.
+func (a *RecordingActuator) Clicks() []RecordedAction {
+	var clicks []RecordedAction
+	for _, action := range a.Actions() {
+		if action.Op == "click" {
+			clicks = append(clicks, action)
+		}
+	}
+	return clicks
+}
+
+func (a *RecordingActuator) Reset() {
+	a.mu.Lock()
+	a.actions = nil
+	a.mu.Unlock()
+}
@@ -0,0 +1,33 @@
+//go:build headless
+
+package automation
+
+import "errors"
+
+var errNoInput = errors.New("сборка без управления мышью и клавиатурой (headless), используйте --dry-run")
+
+// RobotActuator stands in for the real mouse and keyboard in a headless
+// build, which does not link robotgo: every action that needs input fails.
+type RobotActuator struct{}
+
+func NewRobotActuator() *RobotActuator {
+	return &RobotActuator{}
+}
+
+func (RobotActuator) Unavailable() error {
+	return errNoInput
+}
+
+func (RobotActuator) Move(x, y int)                        {}
+func (RobotActuator) Click(button string, double bool)     {}
+func (RobotActuator) MouseToggle(button string, down bool) {}
+func (RobotActuator) Type(text string)                     {}
+func (RobotActuator) Scroll(dx, dy int)                    {}
+
+func (RobotActuator) Press(key string, modifiers ...string) error {
+	return errNoInput
+}
+
+func (RobotActuator) KeyToggle(key string, down bool) error {
+	return errNoInput
+}
@@ -0,0 +1,35 @@
+//go:build headless
+
+package automation
+
+import (
+	"context"
+	"image"
+	"testing"
+)
+
+func TestHeadlessInputFails(t *testing.T) {
+	config := loopConfig()
+	config.Rules = []Rule{{
+		When:    Condition{Type: CondAlways},
+		Actions: []Action{{Type: ActionKey, Key: "enter"}},
+	}}
+
+	bus := NewBus()
+	events := bus.Subscribe(64, DropOldest)
+	RunOnce(context.Background(), config, bus, WithScreen(NewMemoryScreen(image.NewRGBA(image.Rect(0, 0, 100, 100)))))
+	bus.Close()
+
+	failed := false
+	for e := range events.Events() {
+		switch e := e.(type) {
+		case *ActionPerformed:
+			t.Errorf("performed %s without input", e.Action)
+		case *EngineError:
+			failed = failed || (e.Op == OpAction && e.Err == errNoInput.Error())
+		}
+	}
+	if !failed {
+		t.Error("no action error reported")
+	}
+}
@@ -0,0 +1,80 @@
+//go:build !headless
+
+package automation
+
+import (
+	"math"
+
+	"github.com/go-vgo/robotgo"
+)
+
+// RobotActuator drives the real mouse and keyboard through robotgo.
+type RobotActuator struct{}
+
+func NewRobotActuator() *RobotActuator {
+	return &RobotActuator{}
+}
+
+func (RobotActuator) Move(x, y int) {
+	robotgo.Move(robotPoint(x), robotPoint(y))
+}
+
+// robotPoint converts a virtual desktop coordinate, in the pixels the
+// screenshot package captures, to robotgo's click space. On Windows robotgo
+// divides every target by the scale factor of the primary display (see
+// robotgo.MoveScale), which would send clicks on a scaled display, or on any
+// display right of or below it, to the wrong place. The factor is measured
+// through MoveScale itself, so that the conversion is its exact inverse.
+func robotPoint(v int) int {
+	const probe = 1 << 20
+	scaled, _ := robotgo.MoveScale(probe, probe)
+	if scaled == probe || scaled == 0 {
+		return v
+	}
+	f := float64(probe) / float64(scaled)
+
+	// robotgo truncates toward zero; round away from it so the result lands
+	// back on v and not on its neighbour.
+	if v < 0 {
+		return int(math.Floor(float64(v) * f))
+	}
+	return int(math.Ceil(float64(v) * f))
+}
+
+func (RobotActuator) Click(button string, double bool) {
+	robotgo.Click(button, double)
+}
+
+func (RobotActuator) MouseToggle(button string, down bool) {
+	if down {
+		robotgo.Toggle(button)
+	} else {
+		robotgo.Toggle(button, "up")
+	}
+}
+
+// keyTap is the call into robotgo, replaced in tests.
+var keyTap = robotgo.KeyTap
+
+// Press taps key with shift added for the keys that need it. robotgo adds
+// shift for an uppercase letter or a shifted symbol like "+" only when it
+// gets no modifier list at all, and drops it when it does.
+func (RobotActuator) Press(key string, modifiers ...string) error {
+	key, modifiers = shifted(key, modifiers)
+	return keyTap(key, modifiers)
+}
+
+func (RobotActuator) KeyToggle(key string, down bool) error {
+	if down {
+		return robotgo.KeyToggle(key)
+	}
+	return robotgo.KeyToggle(key, "up")
+}
+
+func (RobotActuator) Type(text string) {
+	robotgo.TypeStr(text)
+}
+
+func (RobotActuator) Scroll(dx, dy int) {
+	robotgo.Scroll(dx, dy)
+}
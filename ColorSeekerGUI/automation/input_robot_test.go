@@ -0,0 +1,40 @@
+//go:build !headless
+
+package automation
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRobotPressAddsShift(t *testing.T) {
+	// What reaches robotgo must carry shift itself: robotgo drops its own
+	// once it is given a modifier list.
+	var got string
+	defer func(tap func(string, ...interface{}) error) { keyTap = tap }(keyTap)
+	keyTap = func(key string, args ...interface{}) error {
+		got = fmt.Sprint(key, args)
+		return nil
+	}
+
+	for _, tc := range []struct {
+		key       string
+		modifiers []string
+		want      string
+	}{
+		{"a", nil, "a[[]]"},
+		{"enter", []string{"ctrl"}, "enter[[ctrl]]"},
+		{"A", nil, "a[[shift]]"},
+		{"+", nil, "=[[shift]]"},
+		{"+", []string{"ctrl"}, "=[[ctrl shift]]"},
+		{"?", []string{"shift"}, "/[[shift]]"},
+		{"=", nil, "=[[]]"},
+	} {
+		if err := (RobotActuator{}).Press(tc.key, tc.modifiers...); err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("Press(%q, %q) called robotgo with %s, want %s", tc.key, tc.modifiers, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,365 @@
+package automation
+
+import (
+	"context"
+	"image"
+	"math"
+)
+
+// Template matching metrics for Config.MatchMetric. Every metric yields a
+// similarity score in 0..1 where 1 is a pixel-perfect match, so the same
+// MatchThreshold can be used with any of them:
+//
+//   - MetricSAD: 1 - Σ|I-T| / (N·3·255), the mean absolute RGB difference.
+//   - MetricSSD: 1 - Σ(I-T)² / (N·3·255²); large differences weigh more.
+//   - MetricNCC: zero-mean normalized cross-correlation, with each RGB channel
+//     centred on its own mean. It ignores uniform brightness and tint shifts.
+//     Negative correlation is clamped to 0; two flat regions are compared by
+//     their mean colors instead.
+const (
+	MetricSAD = "sad"
+	MetricSSD = "ssd"
+	MetricNCC = "ncc"
+)
+
+// Match is a template hit. Size is the template size at the winning Scale,
+// which is what click points have to be computed from.
+type Match struct {
+	Location image.Point `json:"location"`
+	Size     image.Point `json:"size"`
+	Scale    float64     `json:"scale"`
+	Score    float64     `json:"score"`
+}
+
+type matcher struct {
+	ctx        context.Context
+	metric     string
+	levels     int
+	candidates int
+	scales     []float64
+	workers    int
+	threshold  float64
+	early      bool
+	order      string
+
+	// screens, when set, shares the screen pyramids between searches.
+	screens screenCache
+
+	// stats adds up the work of every search of the matcher.
+	stats MatchStats
+}
+
+func newMatcher(config Config) *matcher {
+	return &matcher{
+		ctx:        context.Background(),
+		metric:     config.MatchMetric,
+		levels:     max(config.PyramidLevels, 0),
+		candidates: max(config.PyramidCandidates, 1),
+		scales:     config.templateScales(),
+		workers:    searchWorkers(config.SearchWorkers),
+		threshold:  config.MatchThreshold,
+		early:      config.MatchEarlyExit,
+		order:      config.MatchOrder,
+	}
+}
+
+// FindBest returns the best placement of template in screen, whatever its
+// score; callers compare Score with config.MatchThreshold themselves. With
+// config.MatchEarlyExit a placement below the threshold is only the first
+// pyramid candidate.
+func FindBest(screen image.Image, template image.Image, config Config) (Match, error) {
+	t, err := prepareTemplate(template, nil)
+	if err != nil {
+		return Match{}, err
+	}
+
+	return newMatcher(config).findBest(screen, t)
+}
+
+// findBest searches the pyramid for every configured scale and keeps the
+// best. It fails only when the matcher's context is cancelled.
+func (m *matcher) findBest(screen image.Image, template *templateImage) (Match, error) {
+	screens, scaledTemplates := m.prepare(screen, template)
+	best := Match{Score: -1.0}
+
+	for i, scale := range m.scales {
+		scaled := scaledTemplates[i]
+		if !fits(scaled, screens[0]) {
+			continue
+		}
+
+		found, err := m.bestOnPyramid(screens, templatePyramid(scaled, screens))
+		if err != nil {
+			return Match{}, err
+		}
+		if found.score > best.Score {
+			best = Match{Location: found.loc, Size: scaled.Size(), Scale: scale, Score: found.score}
+		}
+	}
+
+	return best, nil
+}
+
+// bestOnPyramid refines the pyramid candidates on the full size screen. They
+// are searched one after another, each only for a better score than the ones
+// before, which early exit can bound by.
+func (m *matcher) bestOnPyramid(screens []*pixels, templates []*templateImage) (candidate, error) {
+	points, err := m.pyramidCandidates(screens, templates, m.candidates)
+	if err != nil {
+		return candidate{}, err
+	}
+
+	img, template := screens[0], templates[0]
+	radius := refineRadius(0, len(templates)-1)
+	best := candidate{score: -1.0}
+	for _, p := range points {
+		if best, err = m.searchAround(img, template, p, radius, best, m.threshold); err != nil {
+			return candidate{}, err
+		}
+	}
+	if best.score < 0 && len(points) > 0 {
+		// Early exit gave up on every position, as none reaches the
+		// threshold; report the first candidate as it scores.
+		best = candidate{loc: points[0], score: m.compareRegion(img, template, points[0].X, points[0].Y)}
+	}
+	return best, nil
+}
+
+// candidate is a scored template position.
+type candidate struct {
+	loc   image.Point
+	score float64
+}
+
+// rowResult is what a worker found in one row of a search.
+type rowResult struct {
+	best  candidate
+	stats MatchStats
+}
+
+// bestIn compares template at every step-th position of area, whose Max is
+// inclusive, and returns the best one, or start if none beats it. Of equal
+// scores the first in reading order wins, whichever worker found it, so the
+// result is the same as of a single loop over all positions.
+//
+// With early exit, a candidate is given up once it cannot beat start or the
+// best earlier in its row, or cannot reach threshold. The bound stays within
+// the row, which keeps both the result and the statistics independent of the
+// scheduling.
+func (m *matcher) bestIn(img *pixels, template *templateImage, area image.Rectangle, step int, start candidate, threshold float64) (candidate, error) {
+	if area.Max.X < area.Min.X || area.Max.Y < area.Min.Y {
+		return start, nil
+	}
+
+	early := m.earlyExit()
+	var visit []int
+	if early && m.order == OrderContrast {
+		visit = contrastRows(template)
+	}
+
+	rows := make([]rowResult, (area.Max.Y-area.Min.Y)/step+1)
+	err := m.forRows(len(rows), func(row int) {
+		y := area.Min.Y + row*step
+		res := rowResult{best: candidate{score: math.Inf(-1)}}
+		for x := area.Min.X; x <= area.Max.X; x += step {
+			var score float64
+			if early {
+				lim := limit{best: math.Max(start.score, res.best.score), threshold: threshold}
+				var ok bool
+				if score, ok = m.compareEarly(img, template, visit, x, y, lim, &res.stats); !ok {
+					continue
+				}
+			} else {
+				score = m.compareRegion(img, template, x, y)
+				res.stats.Candidates++
+				res.stats.Pixels += int64(template.count)
+			}
+			if score > res.best.score {
+				res.best = candidate{loc: image.Point{X: x, Y: y}, score: score}
+			}
+		}
+		rows[row] = res
+	})
+	if err != nil {
+		return candidate{}, err
+	}
+
+	best := start
+	for _, row := range rows {
+		m.stats.add(row.stats)
+		if row.best.score > best.score {
+			best = row.best
+		}
+	}
+	return best, nil
+}
+
+func (m *matcher) compareRegion(img *pixels, template *templateImage, startX, startY int) float64 {
+	switch m.metric {
+	case MetricSSD:
+		return compareSSD(img, template, startX, startY)
+	case MetricNCC:
+		return compareNCC(img, template, startX, startY)
+	default:
+		return compareSAD(img, template, startX, startY)
+	}
+}
+
+// The compare functions sum integers and convert once at the end; the sums
+// stay far below 2^53, so the scores are exactly those of float accumulation.
+// Each row goes to a loop without the mask check when every pixel counts.
+
+func compareSAD(img *pixels, template *templateImage, startX, startY int) float64 {
+	size := template.Size()
+
+	var totalDiff int
+	var maxDiff float64 = float64(template.count * 255 * 3)
+
+	for ty := 0; ty < size.Y; ty++ {
+		row := img.row(startX, startY+ty, size.X)
+		trow, mask := template.row(ty)
+		if mask == nil {
+			totalDiff += sadRow(row, trow)
+			continue
+		}
+		for tx, i := 0, 0; i < len(trow); tx, i = tx+1, i+4 {
+			if mask[tx] {
+				totalDiff += sadRow(row[i:i+4], trow[i:i+4])
+			}
+		}
+	}
+
+	similarity := 1.0 - (float64(totalDiff) / maxDiff)
+	return similarity
+}
+
+func sadRow(row, trow []uint8) int {
+	var sum int
+	row = row[:len(trow)]
+	for i := 3; i < len(trow); i += 4 {
+		sum += absDiff(row[i-3], trow[i-3]) + absDiff(row[i-2], trow[i-2]) + absDiff(row[i-1], trow[i-1])
+	}
+	return sum
+}
+
+// absDiff is |a-b| without a branch, which random screen content would
+// mispredict half the time.
+func absDiff(a, b uint8) int {
+	d := int(a) - int(b)
+	m := d >> 63
+	return (d ^ m) - m
+}
+
+func compareSSD(img *pixels, template *templateImage, startX, startY int) float64 {
+	size := template.Size()
+
+	var totalDiff int
+	var maxDiff float64 = float64(template.count*3) * 255 * 255
+
+	for ty := 0; ty < size.Y; ty++ {
+		row := img.row(startX, startY+ty, size.X)
+		trow, mask := template.row(ty)
+		if mask == nil {
+			totalDiff += ssdRow(row, trow)
+			continue
+		}
+		for tx, i := 0, 0; i < len(trow); tx, i = tx+1, i+4 {
+			if mask[tx] {
+				totalDiff += ssdRow(row[i:i+4], trow[i:i+4])
+			}
+		}
+	}
+
+	return 1.0 - (float64(totalDiff) / maxDiff)
+}
+
+func ssdRow(row, trow []uint8) int {
+	var sum int
+	row = row[:len(trow)]
+	for i := 3; i < len(trow); i += 4 {
+		dr := int(row[i-3]) - int(trow[i-3])
+		dg := int(row[i-2]) - int(trow[i-2])
+		db := int(row[i-1]) - int(trow[i-1])
+		sum += dr*dr + dg*dg + db*db
+	}
+	return sum
+}
+
+// nccSums are the sums NCC needs from the screen side; the template's own are
+// precomputed. Only the per-channel sums are kept apart: the variance and the
+// covariance add up over the channels anyway, so one sum of squares and one
+// of products are enough.
+type nccSums struct {
+	i      [3]int64
+	ii, it int64
+}
+
+func compareNCC(img *pixels, template *templateImage, startX, startY int) float64 {
+	size := template.Size()
+
+	var s nccSums
+	for ty := 0; ty < size.Y; ty++ {
+		row := img.row(startX, startY+ty, size.X)
+		trow, mask := template.row(ty)
+		if mask == nil {
+			s.addRow(row, trow)
+			continue
+		}
+		for tx, i := 0, 0; i < len(trow); tx, i = tx+1, i+4 {
+			if mask[tx] {
+				s.addRow(row[i:i+4], trow[i:i+4])
+			}
+		}
+	}
+
+	return nccScore(int64(template.count), s.i, template.sum, s.ii, template.sumSq, s.it)
+}
+
+// addRow adds up a row. The products with the template share a
+// multiplication with the squares: (t<<32 + v)·v carries v·t in the upper
+// and v² in the lower 32 bits, and neither half overflows within a row of
+// up to 22000 pixels.
+func (s *nccSums) addRow(row, trow []uint8) {
+	var ir, ig, ib int64
+	var acc uint64
+	row = row[:len(trow)]
+	for i := 3; i < len(trow); i += 4 {
+		r, g, b := uint64(row[i-3]), uint64(row[i-2]), uint64(row[i-1])
+		ir += int64(r)
+		ig += int64(g)
+		ib += int64(b)
+		acc += (uint64(trow[i-3])<<32+r)*r + (uint64(trow[i-2])<<32+g)*g + (uint64(trow[i-1])<<32+b)*b
+	}
+	s.i[0], s.i[1], s.i[2] = s.i[0]+ir, s.i[1]+ig, s.i[2]+ib
+	s.ii += int64(acc & 0xffffffff)
+	s.it += int64(acc >> 32)
+}
+
+// nccScore computes the score from the sums over n pixels. Covariance and
+// variances are kept multiplied by n, which leaves them exact integers; they
+// fit in 64 bits for any template that fits on a screen.
+func nccScore(n int64, sumI, sumT [3]int64, sumII, sumTT, sumIT int64) float64 {
+	cov, varI, varT := n*sumIT, n*sumII, n*sumTT
+	for c := 0; c < 3; c++ {
+		cov -= sumI[c] * sumT[c]
+		varI -= sumI[c] * sumI[c]
+		varT -= sumT[c] * sumT[c]
+	}
+
+	if varI == 0 && varT == 0 {
+		var diff int64
+		for c := 0; c < 3; c++ {
+			d := sumI[c] - sumT[c]
+			if d < 0 {
+				d = -d
+			}
+			diff += d
+		}
+		return 1.0 - float64(diff)/float64(n*3*255)
+	}
+	if varI == 0 || varT == 0 {
+		return 0.0
+	}
+
+	return math.Max(float64(cov)/math.Sqrt(float64(varI)*float64(varT)), 0)
+}
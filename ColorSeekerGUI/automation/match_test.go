@@ -0,0 +1,458 @@
+package automation
+
+import (
+	"context"
+	"image"
+	"image/draw"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// The reference below is the matcher as it was before the packed pixel
+// buffers: every metric reads the screen through At. The fast path has to
+// return exactly the same scores, and the benchmarks compare the two.
+
+func atPixel(img image.Image, x, y int) (int, int, int) {
+	r, g, b, _ := img.At(x, y).RGBA()
+	return int(r >> 8), int(g >> 8), int(b >> 8)
+}
+
+func atScore(metric string, img image.Image, t *templateImage, startX, startY int) float64 {
+	size := t.Size()
+	var sad, ssd float64
+	var sumI, sumT [3]int64
+	var sumII, sumTT, sumIT int64
+
+	for ty := 0; ty < size.Y; ty++ {
+		for tx := 0; tx < size.X; tx++ {
+			if !t.counts(tx, ty) {
+				continue
+			}
+			ir, ig, ib := atPixel(img, startX+tx, startY+ty)
+			tr, tg, tb := t.rgb(tx, ty)
+
+			switch metric {
+			case MetricSSD:
+				dr, dg, db := ir-tr, ig-tg, ib-tb
+				ssd += float64(dr*dr + dg*dg + db*db)
+			case MetricNCC:
+				is := [3]int64{int64(ir), int64(ig), int64(ib)}
+				ts := [3]int64{int64(tr), int64(tg), int64(tb)}
+				for c := 0; c < 3; c++ {
+					sumI[c] += is[c]
+					sumT[c] += ts[c]
+					sumII += is[c] * is[c]
+					sumTT += ts[c] * ts[c]
+					sumIT += is[c] * ts[c]
+				}
+			default:
+				sad += float64(abs(ir-tr) + abs(ig-tg) + abs(ib-tb))
+			}
+		}
+	}
+
+	switch metric {
+	case MetricSSD:
+		return 1.0 - ssd/(float64(t.count*3)*255*255)
+	case MetricNCC:
+		return nccScore(int64(t.count), sumI, sumT, sumII, sumTT, sumIT)
+	default:
+		return 1.0 - sad/float64(t.count*255*3)
+	}
+}
+
+// atFindBest is the search as it was then: a strided pass with the default
+// step of 16 and a refinement within 24 pixels around its best.
+func atFindBest(m *matcher, img image.Image, t *templateImage) (image.Point, float64) {
+	const searchScale, refineRadius = 16, 24
+	b := img.Bounds()
+	size := t.Size()
+	bestLoc, bestScore := image.Point{}, -1.0
+	try := func(x, y int) {
+		if score := atScore(m.metric, img, t, x, y); score > bestScore {
+			bestLoc, bestScore = image.Point{X: x, Y: y}, score
+		}
+	}
+
+	for y := b.Min.Y; y <= b.Max.Y-size.Y; y += searchScale {
+		for x := b.Min.X; x <= b.Max.X-size.X; x += searchScale {
+			try(x, y)
+		}
+	}
+	center := bestLoc
+	for y := max(b.Min.Y, center.Y-refineRadius); y <= min(b.Max.Y-size.Y, center.Y+refineRadius); y++ {
+		for x := max(b.Min.X, center.X-refineRadius); x <= min(b.Max.X-size.X, center.X+refineRadius); x++ {
+			try(x, y)
+		}
+	}
+	return bestLoc, bestScore
+}
+
+// noiseScreen is a random frame and the patch of it used as the template. The
+// patch lies on the grid of 16, so that the strided search of atFindBest
+// finds it too.
+func noiseScreen(w, h int) (*image.RGBA, image.Rectangle) {
+	rng := rand.New(rand.NewSource(1))
+	screen := image.NewRGBA(image.Rect(0, 0, w, h))
+	rng.Read(screen.Pix)
+	for i := 3; i < len(screen.Pix); i += 4 {
+		screen.Pix[i] = 255
+	}
+	x, y := w*3/7/16*16, h*4/9/16*16
+	return screen, image.Rect(x, y, x+32, y+32)
+}
+
+func TestSelectMatchesIgnoresInputOrder(t *testing.T) {
+	// Each hit is within half a height of the next, but the first and the
+	// last are not: the rows must not depend on which is compared first.
+	size := image.Pt(10, 10)
+	hits := []Match{
+		{Location: image.Pt(100, 0), Size: size},
+		{Location: image.Pt(50, 4), Size: size},
+		{Location: image.Pt(0, 8), Size: size},
+	}
+	want := []image.Point{{50, 4}, {100, 0}, {0, 8}}
+
+	for _, perm := range [][]int{{0, 1, 2}, {0, 2, 1}, {1, 0, 2}, {1, 2, 0}, {2, 0, 1}, {2, 1, 0}} {
+		var matches []Match
+		for _, i := range perm {
+			matches = append(matches, hits[i])
+		}
+		all := selectMatches(matches, ClickAll, image.Point{})
+		for i, match := range all {
+			if match.Location != want[i] {
+				t.Errorf("input %v: all %v, want %v", perm, all, want)
+				break
+			}
+		}
+		if first := selectMatches(matches, ClickFirst, image.Point{}); first[0].Location != want[0] {
+			t.Errorf("input %v: first %v, want %v", perm, first[0].Location, want[0])
+		}
+		if last := selectMatches(matches, ClickLast, image.Point{}); last[0].Location != want[2] {
+			t.Errorf("input %v: last %v, want %v", perm, last[0].Location, want[2])
+		}
+	}
+}
+
+func TestCompareRegionMatchesAt(t *testing.T) {
+	screen, patch := noiseScreen(160, 120)
+
+	// An NRGBA screen with translucent pixels and an offset origin goes
+	// through the conversion path of newPixels.
+	nrgba := image.NewNRGBA(image.Rect(-7, 5, 153, 125))
+	rand.New(rand.NewSource(2)).Read(nrgba.Pix)
+
+	mask := image.NewGray(image.Rect(0, 0, 32, 32))
+	for i := range mask.Pix {
+		if i%7 != 0 {
+			mask.Pix[i] = 255
+		}
+	}
+	plain, err := prepareTemplate(screen.SubImage(patch), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	masked, err := prepareTemplate(screen.SubImage(patch), mask)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rng := rand.New(rand.NewSource(3))
+	for _, img := range []image.Image{screen, screen.SubImage(image.Rect(10, 10, 150, 110)), nrgba} {
+		p := newPixels(img)
+		b := img.Bounds()
+		for _, tmpl := range []*templateImage{plain, masked, plain.scaled(0.75)} {
+			size := tmpl.Size()
+			for _, metric := range []string{MetricSAD, MetricSSD, MetricNCC} {
+				m := &matcher{metric: metric}
+				for i := 0; i < 50; i++ {
+					x := b.Min.X + rng.Intn(b.Dx()-size.X+1)
+					y := b.Min.Y + rng.Intn(b.Dy()-size.Y+1)
+					want := atScore(metric, img, tmpl, x, y)
+					if got := m.compareRegion(p, tmpl, x, y); got != want {
+						t.Fatalf("%s at %d,%d: got %v, want %v", metric, x, y, got, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+// flatScreen is a frame of one light color with a little noise, as behind
+// text in a window.
+func flatScreen(w, h int, seed int64) *image.RGBA {
+	rng := rand.New(rand.NewSource(seed))
+	screen := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := range screen.Pix {
+		screen.Pix[i] = 200 + uint8(rng.Intn(4))
+		if i%4 == 3 {
+			screen.Pix[i] = 255
+		}
+	}
+	return screen
+}
+
+func TestPyramidFindsTemplates(t *testing.T) {
+	// The pyramid has to find the templates at odd offsets too, where the
+	// halved screen is out of phase with the halved template.
+	for _, path := range []string{"../bad.png", "../Good.png"} {
+		img, err := loadImage(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template, err := prepareTemplate(img, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, at := range []image.Point{{333, 217}, {87, 51}, {170, 126}} {
+			noise, _ := noiseScreen(480, 320)
+			for bg, screen := range map[string]*image.RGBA{"noise": noise, "flat": flatScreen(480, 320, int64(at.X))} {
+				draw.Draw(screen, img.Bounds().Sub(img.Bounds().Min).Add(at), img, img.Bounds().Min, draw.Src)
+				for _, metric := range []string{MetricSAD, MetricSSD, MetricNCC} {
+					config := DefaultConfig()
+					config.MatchMetric = metric
+					best, _ := newMatcher(config).findBest(screen, template)
+					if best.Location != at || best.Score != 1 {
+						t.Errorf("%s on %s, %s: found %v (%.3f), want %v", path, bg, metric, best.Location, best.Score, at)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestPyramidFindsNarrowPeak(t *testing.T) {
+	// On noise the score peak is a single pixel wide: a strided pass steps
+	// over a patch off its grid, the pyramid has to find it anyway.
+	screen, _ := noiseScreen(200, 150)
+	for _, size := range []int{12, 32, 75} {
+		patch := image.Rect(101, 47, 101+size, 47+size)
+		template, err := prepareTemplate(screen.SubImage(patch), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, metric := range []string{MetricSAD, MetricSSD, MetricNCC} {
+			for levels := 0; levels <= 4; levels++ {
+				config := DefaultConfig()
+				config.MatchMetric = metric
+				config.PyramidLevels = levels
+				best, _ := newMatcher(config).findBest(screen, template)
+				if best.Location != patch.Min || best.Score != 1 {
+					t.Errorf("%s, %d px, %d levels: found %v (%.3f), want %v", metric, size, levels, best.Location, best.Score, patch.Min)
+				}
+			}
+		}
+	}
+}
+
+func TestParallelSearchMatchesSerial(t *testing.T) {
+	screen, patch := noiseScreen(320, 240)
+	// Exact copies of the patch give equal scores, which must be broken the
+	// same way however the rows are spread over the workers.
+	for _, at := range []image.Point{{16, 16}, {240, 16}, {16, 192}, {241, 193}} {
+		draw.Draw(screen, patch.Sub(patch.Min).Add(at), screen, patch.Min, draw.Src)
+	}
+	template, err := prepareTemplate(screen.SubImage(patch), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, metric := range []string{MetricSAD, MetricSSD, MetricNCC} {
+		config := DefaultConfig()
+		config.MatchMetric = metric
+		config.ScaleMin, config.ScaleMax = 0.75, 1.25
+
+		config.SearchWorkers = 1
+		serial := newMatcher(config)
+		wantBest, _ := serial.findBest(screen, template)
+		wantAll, _ := serial.findAll(screen, template, 0.5, 10, 0.3)
+
+		for _, workers := range []int{2, 3, 8} {
+			config.SearchWorkers = workers
+			m := newMatcher(config)
+			if best, _ := m.findBest(screen, template); best != wantBest {
+				t.Errorf("%s, %d workers: best %+v, serial %+v", metric, workers, best, wantBest)
+			}
+			if all, _ := m.findAll(screen, template, 0.5, 10, 0.3); !reflect.DeepEqual(all, wantAll) {
+				t.Errorf("%s, %d workers: all %+v, serial %+v", metric, workers, all, wantAll)
+			}
+		}
+	}
+}
+
+func TestScreenCacheSharesPyramids(t *testing.T) {
+	screen, _ := noiseScreen(320, 240)
+	// The small template needs fewer levels than the large one, which the
+	// cached pyramid then has to grow by.
+	var templates []*templateImage
+	for _, patch := range []image.Rectangle{image.Rect(40, 30, 58, 48), image.Rect(150, 90, 214, 154)} {
+		template, err := prepareTemplate(screen.SubImage(patch), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		templates = append(templates, template)
+	}
+
+	config := DefaultConfig()
+	shared := newMatcher(config)
+	shared.screens = make(screenCache)
+	for _, template := range templates {
+		want, _ := newMatcher(config).findBest(screen, template)
+		if best, _ := shared.findBest(screen, template); best != want {
+			t.Errorf("%v template: best %+v with the cache, %+v without", template.Size(), best, want)
+		}
+		wantAll, _ := newMatcher(config).findAll(screen, template, 0.5, 10, 0.3)
+		if all, _ := shared.findAll(screen, template, 0.5, 10, 0.3); !reflect.DeepEqual(all, wantAll) {
+			t.Errorf("%v template: all %+v with the cache, %+v without", template.Size(), all, wantAll)
+		}
+	}
+
+	pyramid := shared.screens[screen.Bounds()]
+	if len(shared.screens) != 1 || len(pyramid) != config.PyramidLevels+1 {
+		t.Fatalf("cached %d pyramids, %d levels; want one of %d", len(shared.screens), len(pyramid), config.PyramidLevels+1)
+	}
+	if again := shared.screens.pyramid(screen, 1); again[0] != pyramid[0] || again[1] != pyramid[1] || len(again) != 2 {
+		t.Errorf("a shallower pyramid was built anew")
+	}
+}
+
+func TestSearchCancelled(t *testing.T) {
+	screen, patch := noiseScreen(320, 240)
+	template, err := prepareTemplate(screen.SubImage(patch), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, workers := range []int{1, 4} {
+		config := DefaultConfig()
+		config.SearchWorkers = workers
+		m := newMatcher(config)
+		m.ctx = ctx
+		if _, err := m.findBest(screen, template); err != context.Canceled {
+			t.Errorf("findBest with %d workers: %v, want %v", workers, err, context.Canceled)
+		}
+		if _, err := m.findAll(screen, template, 0.5, 10, 0.3); err != context.Canceled {
+			t.Errorf("findAll with %d workers: %v, want %v", workers, err, context.Canceled)
+		}
+	}
+}
+
+func TestEarlyExitMatchesFull(t *testing.T) {
+	screen, patch := noiseScreen(320, 240)
+	template, err := prepareTemplate(screen.SubImage(patch), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, metric := range []string{MetricSAD, MetricSSD} {
+		config := DefaultConfig()
+		config.MatchMetric = metric
+		full := newMatcher(config)
+		wantBest, _ := full.findBest(screen, template)
+		wantAll, _ := full.findAll(screen, template, 0.9, 10, 0.3)
+
+		for _, order := range []string{OrderRaster, OrderContrast} {
+			config.MatchEarlyExit, config.MatchOrder = true, order
+			m := newMatcher(config)
+			if best, _ := m.findBest(screen, template); best != wantBest {
+				t.Errorf("%s, %s: best %+v, full %+v", metric, order, best, wantBest)
+			}
+			if all, _ := m.findAll(screen, template, 0.9, 10, 0.3); !reflect.DeepEqual(all, wantAll) {
+				t.Errorf("%s, %s: all %+v, full %+v", metric, order, all, wantAll)
+			}
+			if m.stats.Abandoned == 0 || m.stats.Skipped == 0 {
+				t.Errorf("%s, %s: nothing abandoned: %+v", metric, order, m.stats)
+			}
+		}
+	}
+}
+
+// BenchmarkCompare measures the pixel access alone: both sides score every
+// position of the same area, on one goroutine, without pyramid or early
+// exit. The packed side includes packing the frame, as a search does.
+func BenchmarkCompare(b *testing.B) {
+	screen, patch := noiseScreen(1920, 1080)
+	template, err := prepareTemplate(screen.SubImage(patch), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	area := image.Rect(patch.Min.X-64, patch.Min.Y-64, patch.Min.X+64, patch.Min.Y+64)
+
+	for _, metric := range []string{MetricSAD, MetricSSD, MetricNCC} {
+		m := newMatcher(Config{MatchMetric: metric})
+		b.Run(metric+"/at", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for y := area.Min.Y; y < area.Max.Y; y++ {
+					for x := area.Min.X; x < area.Max.X; x++ {
+						atScore(metric, screen, template, x, y)
+					}
+				}
+			}
+		})
+		b.Run(metric+"/packed", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				img := newPixels(screen)
+				for y := area.Min.Y; y < area.Max.Y; y++ {
+					for x := area.Min.X; x < area.Max.X; x++ {
+						m.compareRegion(img, template, x, y)
+					}
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkFindBest compares whole searches: the strided search of
+// atFindBest against findBest, which goes through the pyramid, so the ratio
+// is not the one of the pixel access; BenchmarkCompare measures that.
+func BenchmarkFindBest(b *testing.B) {
+	screen, patch := noiseScreen(1920, 1080)
+	template, err := prepareTemplate(screen.SubImage(patch), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, metric := range []string{MetricSAD, MetricSSD, MetricNCC} {
+		config := DefaultConfig()
+		config.MatchMetric = metric
+		m := newMatcher(config)
+
+		b.Run(metric+"/at", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				atFindBest(m, screen, template)
+			}
+		})
+		b.Run(metric+"/packed", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if best, _ := m.findBest(screen, template); best.Location != patch.Min {
+					b.Fatalf("found %v, want %v", best.Location, patch.Min)
+				}
+			}
+		})
+		if metric == MetricNCC {
+			continue
+		}
+
+		// Early exit in both orders, with the share of abandoned candidates
+		// and of compared pixels for tuning.
+		for _, order := range []string{OrderRaster, OrderContrast} {
+			config.MatchEarlyExit, config.MatchOrder = true, order
+			m := newMatcher(config)
+			b.Run(metric+"/early-"+order, func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					m.stats = MatchStats{}
+					if best, _ := m.findBest(screen, template); best.Location != patch.Min {
+						b.Fatalf("found %v, want %v", best.Location, patch.Min)
+					}
+				}
+				b.ReportMetric(float64(m.stats.Abandoned)*100/float64(m.stats.Candidates), "%abandoned")
+				b.ReportMetric(float64(m.stats.Pixels)*100/float64(m.stats.Pixels+m.stats.Skipped), "%pixels")
+			})
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package automation
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// searchWorkers is the number of goroutines a search uses for config value n.
+func searchWorkers(n int) int {
+	if n <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return n
+}
+
+// forRows calls scan for the rows 0..n-1 of a search, spread over the
+// matcher's workers. Rows are handed out one at a time, so that a slow part of
+// the screen does not hold up the others; scan stores its result by row and
+// the caller reduces them in row order, which keeps the outcome independent
+// of the scheduling. forRows checks the context before every row; if it was
+// cancelled before all rows were scanned, forRows returns its error once the
+// running rows are done.
+func (m *matcher) forRows(n int, scan func(row int)) error {
+	workers := min(m.workers, n)
+	if workers <= 1 {
+		for row := 0; row < n; row++ {
+			if err := m.ctx.Err(); err != nil {
+				return err
+			}
+			scan(row)
+		}
+		return nil
+	}
+
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m.ctx.Err() == nil {
+				row := int(next.Add(1) - 1)
+				if row >= n {
+					return
+				}
+				scan(row)
+			}
+		}()
+	}
+	wg.Wait()
+	if next.Load() < int64(n) {
+		return m.ctx.Err()
+	}
+	return nil
+}
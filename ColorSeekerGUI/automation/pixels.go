@@ -0,0 +1,73 @@
+package automation
+
+import (
+	"image"
+	"image/draw"
+)
+
+// pixels is the screen as the match loops read it: packed premultiplied RGBA,
+// 4 bytes per pixel and rows stride bytes apart, so that comparing a
+// candidate is plain byte arithmetic instead of an At call per pixel. The
+// values equal what At().RGBA() reports, shifted to 8 bits.
+type pixels struct {
+	pix    []uint8
+	stride int
+	rect   image.Rectangle
+}
+
+// newPixels wraps img. Captures are *image.RGBA, also when cut out of a
+// frame, and are used in place; other images are converted once.
+func newPixels(img image.Image) *pixels {
+	rgba, ok := img.(*image.RGBA)
+	if !ok {
+		b := img.Bounds()
+		rgba = image.NewRGBA(b)
+		draw.Draw(rgba, b, img, b.Min, draw.Src)
+	}
+	return &pixels{pix: rgba.Pix, stride: rgba.Stride, rect: rgba.Rect}
+}
+
+// row returns n pixels of row y starting at x.
+func (p *pixels) row(x, y, n int) []uint8 {
+	off := (y-p.rect.Min.Y)*p.stride + (x-p.rect.Min.X)*4
+	return p.pix[off : off+n*4]
+}
+
+// half is the image at half the size for a pyramid level: filtered with the
+// binomial kernel 1 3 3 1 in both directions, then every other pixel. A plain
+// 2×2 mean aliases fine detail like text strokes, so that a halved template
+// looks different depending on its phase against the screen; the wider
+// kernel keeps the levels close whatever the phase. Pixels past the edge
+// repeat the edge, and an odd last row or column is dropped. Its origin is
+// 0,0: pixel x, y is centered on the 2×2 block at p.rect.Min + 2·(x, y).
+func (p *pixels) half() *pixels {
+	sw, sh := p.rect.Dx(), p.rect.Dy()
+	w, h := sw/2, sh/2
+	out := &pixels{pix: make([]uint8, w*h*4), stride: w * 4, rect: image.Rect(0, 0, w, h)}
+
+	// col holds the vertically filtered row with one repeated edge pixel on
+	// either side, so that output x reads the 4 pixels from col[8x].
+	col := make([]int32, (sw+2)*4)
+	inner := col[4 : 4+sw*4]
+	for y := 0; y < h; y++ {
+		row := func(sy int) []uint8 {
+			return p.row(p.rect.Min.X, p.rect.Min.Y+sy, sw)[:len(inner)]
+		}
+		r0, r1, r2, r3 := row(max(2*y-1, 0)), row(2*y), row(2*y+1), row(min(2*y+2, sh-1))
+		for i := range inner {
+			inner[i] = int32(r0[i]) + 3*(int32(r1[i])+int32(r2[i])) + int32(r3[i])
+		}
+		copy(col[:4], inner[:4])
+		copy(col[len(col)-4:], inner[len(inner)-4:])
+
+		dst := out.row(0, y, w)
+		for x := 0; x < w; x++ {
+			s, d := col[8*x:8*x+16], dst[4*x:4*x+4]
+			d[0] = uint8((s[0] + 3*(s[4]+s[8]) + s[12] + 32) >> 6)
+			d[1] = uint8((s[1] + 3*(s[5]+s[9]) + s[13] + 32) >> 6)
+			d[2] = uint8((s[2] + 3*(s[6]+s[10]) + s[14] + 32) >> 6)
+			d[3] = uint8((s[3] + 3*(s[7]+s[11]) + s[15] + 32) >> 6)
+		}
+	}
+	return out
+}
@@ -0,0 +1,358 @@
+package automation
+
+import (
+	"image"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// minPyramidSize is the smallest template side a pyramid level may
+	// have; smaller templates match too much of anything to pick candidates.
+	minPyramidSize = 8
+
+	// pyramidCell is the side of the cells the coarsest level is divided
+	// into; each cell offers its best position as a candidate.
+	pyramidCell = 2
+
+	// pyramidPool is how many times the wanted number of candidates the best
+	// cells are ranked before neighbouring cells are dropped.
+	pyramidPool = 4
+
+	// pyramidRadius is how far from the position taken down from the level
+	// above a candidate is searched on the level just below the coarsest.
+	// Halving loses the phase of the template against the screen, which
+	// moves a peak by a pixel or two where the template has fine repeating
+	// detail like text, so one pixel more is searched on every finer level.
+	pyramidRadius = 3
+)
+
+// screenPyramid returns img followed by up to levels successive halvings.
+func screenPyramid(img *pixels, levels int) []*pixels {
+	return grow([]*pixels{img}, levels)
+}
+
+// grow halves the last level of pyramid until it has levels halvings or the
+// last level is too small.
+func grow(pyramid []*pixels, levels int) []*pixels {
+	for len(pyramid) <= levels {
+		last := pyramid[len(pyramid)-1]
+		if last.rect.Dx() < 2 || last.rect.Dy() < 2 {
+			break
+		}
+		pyramid = append(pyramid, last.half())
+	}
+	return pyramid
+}
+
+// screenCache keeps the screen pyramids built from one frame by region, so
+// that the searches of an iteration halve a region once however many
+// templates they look for in it. A nil cache builds every pyramid anew.
+type screenCache map[image.Rectangle][]*pixels
+
+// pyramid returns the pyramid of screen with up to levels halvings, growing
+// the cached one when it is not deep enough.
+func (c screenCache) pyramid(screen image.Image, levels int) []*pixels {
+	if c == nil {
+		return screenPyramid(newPixels(screen), levels)
+	}
+	rect := screen.Bounds()
+	pyramid, ok := c[rect]
+	if !ok {
+		pyramid = []*pixels{newPixels(screen)}
+	}
+	pyramid = grow(pyramid, levels)
+	c[rect] = pyramid
+	return pyramid[:min(len(pyramid), levels+1)]
+}
+
+// prepare scales template to every scale of the matcher and builds the
+// screen pyramid as deep as the largest of them can follow: halving a full
+// frame takes a third as long as the coarse pass, so levels that no template
+// reaches are not built.
+func (m *matcher) prepare(screen image.Image, template *templateImage) ([]*pixels, []*templateImage) {
+	templates := make([]*templateImage, len(m.scales))
+	depth := 0
+	for i, scale := range m.scales {
+		templates[i] = template.scaled(scale)
+		depth = max(depth, pyramidDepth(templates[i].Size()))
+	}
+	return m.screens.pyramid(screen, min(m.levels, depth)), templates
+}
+
+// templatePyramid halves t alongside the screen pyramid for as long as the
+// halved template keeps minPyramidSize, has pixels that count and fits on its
+// level of the screen.
+func templatePyramid(t *templateImage, screens []*pixels) []*templateImage {
+	pyramid := []*templateImage{t}
+	for len(pyramid) < min(len(screens), pyramidDepth(t.Size())+1) {
+		next := pyramid[len(pyramid)-1].half()
+		if next.count == 0 || !fits(next, screens[len(pyramid)]) {
+			break
+		}
+		pyramid = append(pyramid, next)
+	}
+	return pyramid
+}
+
+// pyramidDepth is how many times a template of size can be halved before a
+// side gets shorter than minPyramidSize.
+func pyramidDepth(size image.Point) int {
+	depth := 0
+	for size.X/2 >= minPyramidSize && size.Y/2 >= minPyramidSize {
+		size = size.Div(2)
+		depth++
+	}
+	return depth
+}
+
+func fits(t *templateImage, img *pixels) bool {
+	size, imgSize := t.Size(), img.rect.Size()
+	return size.X <= imgSize.X && size.Y <= imgSize.Y
+}
+
+// pyramidCandidates picks the keep best cells of the coarsest level and
+// follows each of them down the pyramid, searching around it on every level.
+// The result, in order of the coarse scores, are the positions on level 0
+// for the caller to search around with its own bounds, within
+// pyramidRadius(0, len(templates)-1).
+func (m *matcher) pyramidCandidates(screens []*pixels, templates []*templateImage, keep int) ([]image.Point, error) {
+	top := len(templates) - 1
+	points, err := m.coarseCandidates(screens[top], templates[top], keep)
+	if err != nil {
+		return nil, err
+	}
+
+	for level := top - 1; level >= 0; level-- {
+		for i, p := range points {
+			center := down(p, screens[level+1], screens[level], templates[level])
+			if level == 0 {
+				points[i] = center
+				continue
+			}
+			start := candidate{loc: center, score: -1.0}
+			refined, err := m.searchAround(screens[level], templates[level], center, refineRadius(level, top), start, math.Inf(-1))
+			if err != nil {
+				return nil, err
+			}
+			points[i] = refined.loc
+		}
+	}
+	return points, nil
+}
+
+// refineRadius is the search radius on level for a pyramid of top levels.
+func refineRadius(level, top int) int {
+	return pyramidRadius + top - 1 - level
+}
+
+// down maps position p on level from to the level below it, clamped to the
+// positions where t fits.
+func down(p image.Point, from, to *pixels, t *templateImage) image.Point {
+	p = to.rect.Min.Add(p.Sub(from.rect.Min).Mul(2))
+	return clampPosition(p, to, t)
+}
+
+func clampPosition(p image.Point, img *pixels, t *templateImage) image.Point {
+	b, size := img.rect, t.Size()
+	p.X = max(b.Min.X, min(p.X, b.Max.X-size.X))
+	p.Y = max(b.Min.Y, min(p.Y, b.Max.Y-size.Y))
+	return p
+}
+
+// searchAround looks for a position better than start within radius of
+// center. With early exit, candidates that cannot reach threshold are given
+// up too.
+func (m *matcher) searchAround(img *pixels, t *templateImage, center image.Point, radius int, start candidate, threshold float64) (candidate, error) {
+	b, size := img.rect, t.Size()
+	area := image.Rectangle{
+		Min: image.Pt(max(b.Min.X, center.X-radius), max(b.Min.Y, center.Y-radius)),
+		Max: image.Pt(min(b.Max.X-size.X, center.X+radius), min(b.Max.Y-size.Y, center.Y+radius)),
+	}
+	return m.bestIn(img, t, area, 1, start, threshold)
+}
+
+// coarseCandidates scores every position of template on a pyramid level,
+// divided into cells of pyramidCell² positions, and returns the best position
+// of each of the keep best cells, best first. Cells keep a peak from flooding
+// the candidates with its own slopes, while two peaks close together both
+// stay in the running.
+//
+// The cells are scanned in bands of pyramidCell rows, so that every cell
+// belongs to one worker. With early exit a position is given up once it
+// cannot reach the keep-th best cell so far: cell maxima only grow, so it
+// could not have made it at the end either, and the result does not depend
+// on the scheduling. The statistics do.
+func (m *matcher) coarseCandidates(img *pixels, template *templateImage, keep int) ([]image.Point, error) {
+	if !fits(template, img) {
+		return nil, nil
+	}
+
+	b, size := img.rect, template.Size()
+	w, h := b.Dx()-size.X+1, b.Dy()-size.Y+1
+	top := newCellRanking((w+pyramidCell-1)/pyramidCell, (h+pyramidCell-1)/pyramidCell, keep*pyramidPool)
+
+	early := m.earlyExit()
+	var visit []int
+	if early && m.order == OrderContrast {
+		visit = contrastRows(template)
+	}
+
+	bands := make([]MatchStats, (h+pyramidCell-1)/pyramidCell)
+	err := m.forRows(len(bands), func(band int) {
+		stats := &bands[band]
+		for row := band * pyramidCell; row < min(h, (band+1)*pyramidCell); row++ {
+			for col := 0; col < w; col++ {
+				x, y := b.Min.X+col, b.Min.Y+row
+				var score float64
+				if early {
+					var ok bool
+					if score, ok = m.compareEarly(img, template, visit, x, y, limit{best: math.Inf(-1), threshold: top.bound()}, stats); !ok {
+						continue
+					}
+				} else {
+					score = m.compareRegion(img, template, x, y)
+					stats.Candidates++
+					stats.Pixels += int64(template.count)
+				}
+				top.offer(col/pyramidCell, band, candidate{loc: image.Pt(x, y), score: score})
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, stats := range bands {
+		m.stats.add(stats)
+	}
+	return top.best(keep), nil
+}
+
+// cellRanking keeps the best candidate of every cell and, for early exit,
+// the score of the keep-th best cell.
+type cellRanking struct {
+	cells []candidate
+	w     int
+	keep  int
+
+	mu      sync.Mutex
+	leaders []rankedCell  // the keep best cells so far, best first
+	last    atomic.Uint64 // float64 bits of the keep-th best score
+}
+
+type rankedCell struct {
+	index int
+	score float64
+}
+
+func newCellRanking(w, h, keep int) *cellRanking {
+	r := &cellRanking{cells: make([]candidate, w*h), w: w, keep: keep}
+	for i := range r.cells {
+		r.cells[i].score = math.Inf(-1)
+	}
+	r.last.Store(math.Float64bits(math.Inf(-1)))
+	return r
+}
+
+// bound is the score a position has to reach to still matter.
+func (r *cellRanking) bound() float64 {
+	return math.Float64frombits(r.last.Load())
+}
+
+// offer records c for cell x, y. Every cell is offered its positions by one
+// worker in reading order, so the first of equal scores is kept.
+func (r *cellRanking) offer(x, y int, c candidate) {
+	i := y*r.w + x
+	if c.score <= r.cells[i].score {
+		return
+	}
+	r.cells[i] = c
+	if c.score <= r.bound() {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	leaders := r.leaders
+	for j, cell := range leaders {
+		if cell.index == i {
+			leaders = append(leaders[:j], leaders[j+1:]...)
+			break
+		}
+	}
+	pos := len(leaders)
+	for pos > 0 && c.score > leaders[pos-1].score {
+		pos--
+	}
+	if pos >= r.keep {
+		return
+	}
+	leaders = append(leaders, rankedCell{})
+	copy(leaders[pos+1:], leaders[pos:])
+	leaders[pos] = rankedCell{index: i, score: c.score}
+	if len(leaders) > r.keep {
+		leaders = leaders[:r.keep]
+	}
+	r.leaders = leaders
+	if len(leaders) == r.keep {
+		r.last.Store(math.Float64bits(leaders[r.keep-1].score))
+	}
+}
+
+// best returns the positions of the n best cells among the ranked ones,
+// best first and of equal scores the first in reading order. A cell next to
+// a better one already taken is skipped: it is the same peak.
+func (r *cellRanking) best(n int) []image.Point {
+	var ranked []int
+	for i, c := range r.cells {
+		if math.IsInf(c.score, -1) {
+			continue
+		}
+		pos := len(ranked)
+		for pos > 0 && before(c, r.cells[ranked[pos-1]]) {
+			pos--
+		}
+		if pos >= r.keep {
+			continue
+		}
+		ranked = append(ranked, 0)
+		copy(ranked[pos+1:], ranked[pos:])
+		ranked[pos] = i
+		if len(ranked) > r.keep {
+			ranked = ranked[:r.keep]
+		}
+	}
+
+	var taken []int
+	var points []image.Point
+	for _, i := range ranked {
+		if len(points) == n {
+			break
+		}
+		near := false
+		for _, j := range taken {
+			if abs(i%r.w-j%r.w) <= 1 && abs(i/r.w-j/r.w) <= 1 {
+				near = true
+				break
+			}
+		}
+		if !near {
+			taken = append(taken, i)
+			points = append(points, r.cells[i].loc)
+		}
+	}
+	return points
+}
+
+// before orders candidates by score, then in reading order.
+func before(a, b candidate) bool {
+	if a.score != b.score {
+		return a.score > b.score
+	}
+	if a.loc.Y != b.loc.Y {
+		return a.loc.Y < b.loc.Y
+	}
+	return a.loc.X < b.loc.X
+}
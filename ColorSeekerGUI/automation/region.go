@@ -0,0 +1,56 @@
+package automation
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// SearchRegion limits a template search to part of the display. Absolute
+// regions are in screen pixels; relative ones are fractions (0..1) of the
+// display size, measured from its top-left corner.
+type SearchRegion struct {
+	X        float64 `json:"x"`
+	Y        float64 `json:"y"`
+	Width    float64 `json:"width"`
+	Height   float64 `json:"height"`
+	Relative bool    `json:"relative,omitempty"`
+}
+
+// Resolve converts the region to screen coordinates and clips it to bounds.
+// A nil region means the whole display.
+func (s *SearchRegion) Resolve(bounds image.Rectangle) image.Rectangle {
+	if s == nil {
+		return bounds
+	}
+
+	if s.Relative {
+		size := bounds.Size()
+		x := bounds.Min.X + int(math.Round(s.X*float64(size.X)))
+		y := bounds.Min.Y + int(math.Round(s.Y*float64(size.Y)))
+		w := int(math.Round(s.Width * float64(size.X)))
+		h := int(math.Round(s.Height * float64(size.Y)))
+		return image.Rect(x, y, x+w, y+h).Intersect(bounds)
+	}
+
+	x, y := int(s.X), int(s.Y)
+	return image.Rect(x, y, x+int(s.Width), y+int(s.Height)).Intersect(bounds)
+}
+
+func (s *SearchRegion) String() string {
+	if s == nil {
+		return "весь экран"
+	}
+	if s.Relative {
+		return fmt.Sprintf("%.0f%%,%.0f%% %.0f%%×%.0f%%", s.X*100, s.Y*100, s.Width*100, s.Height*100)
+	}
+	return fmt.Sprintf("X=%.0f, Y=%.0f, %.0f×%.0f", s.X, s.Y, s.Width, s.Height)
+}
+
+// TemplateRef is one template entry of the configuration: the PNG to look
+// for, an optional mask and an optional search region.
+type TemplateRef struct {
+	Path   string        `json:"path"`
+	Mask   string        `json:"mask,omitempty"`
+	Region *SearchRegion `json:"region,omitempty"`
+}
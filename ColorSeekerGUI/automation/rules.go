@@ -0,0 +1,215 @@
+package automation
+
+import (
+	"fmt"
+	"image"
+	"path/filepath"
+	"strings"
+)
+
+// Condition types. "and" and "or" combine Conditions; "not" negates
+// Conditions[0]. "color" is the color probe configured by the Color* fields.
+const (
+	CondAlways         = "always"
+	CondColor          = "color"
+	CondTemplate       = "template"
+	CondTemplateAbsent = "template_absent"
+	CondAnd            = "and"
+	CondOr             = "or"
+	CondNot            = "not"
+)
+
+type Condition struct {
+	Type       string       `json:"type"`
+	Template   *TemplateRef `json:"template,omitempty"`
+	Conditions []Condition  `json:"conditions,omitempty"`
+}
+
+// Rule runs its actions when its condition holds. Rules are evaluated in
+// order every iteration and only the first matching rule runs.
+type Rule struct {
+	Name    string    `json:"name,omitempty"`
+	When    Condition `json:"when"`
+	Actions []Action  `json:"actions"`
+}
+
+func (c Condition) String() string {
+	switch c.Type {
+	case CondAlways:
+		return "всегда"
+	case CondColor:
+		return "цвет"
+	case CondTemplate, CondTemplateAbsent:
+		name := "?"
+		if c.Template != nil {
+			name = c.Template.Path
+		}
+		if c.Type == CondTemplateAbsent {
+			return "нет шаблона " + name
+		}
+		return "шаблон " + name
+	case CondAnd, CondOr:
+		sep := " И "
+		if c.Type == CondOr {
+			sep = " ИЛИ "
+		}
+		parts := make([]string, 0, len(c.Conditions))
+		for _, sub := range c.Conditions {
+			parts = append(parts, sub.String())
+		}
+		return "(" + strings.Join(parts, sep) + ")"
+	case CondNot:
+		if len(c.Conditions) == 0 {
+			return "НЕ ?"
+		}
+		return "НЕ " + c.Conditions[0].String()
+	default:
+		return c.Type
+	}
+}
+
+func (r Rule) String() string {
+	actions := make([]string, 0, len(r.Actions))
+	for _, action := range r.Actions {
+		actions = append(actions, action.String())
+	}
+
+	text := fmt.Sprintf("если %s → %s", r.When, strings.Join(actions, ", "))
+	if r.Name != "" {
+		text = r.Name + ": " + text
+	}
+	return text
+}
+
+// legacyConfig holds the fields config.json had before rules existed. Files
+// without "rules" are migrated into the equivalent two rules on load.
+type legacyConfig struct {
+	GoodImagePath string        `json:"good_image_path"`
+	BadImagePath  string        `json:"bad_image_path"`
+	GoodMaskPath  string        `json:"good_mask_path"`
+	BadMaskPath   string        `json:"bad_mask_path"`
+	GoodRegion    *SearchRegion `json:"good_region"`
+	BadRegion     *SearchRegion `json:"bad_region"`
+	ClickTarget   string        `json:"click_target"`
+	ClickNearX    int           `json:"click_near_x"`
+	ClickNearY    int           `json:"click_near_y"`
+}
+
+func (l legacyConfig) rules() []Rule {
+	good := TemplateRef{Path: l.GoodImagePath, Mask: l.GoodMaskPath, Region: l.GoodRegion}
+	if good.Path == "" {
+		good.Path = "Good.png"
+	}
+	bad := TemplateRef{Path: l.BadImagePath, Mask: l.BadMaskPath, Region: l.BadRegion}
+	if bad.Path == "" {
+		bad.Path = "bad.png"
+	}
+
+	click := func(ref TemplateRef) Action {
+		return Action{Type: ActionClick, Template: &ref, Select: l.ClickTarget, NearX: l.ClickNearX, NearY: l.ClickNearY}
+	}
+
+	return []Rule{
+		{
+			Name:    "Цвет найден → " + filepath.Base(good.Path),
+			When:    Condition{Type: CondColor},
+			Actions: []Action{click(good)},
+		},
+		{
+			Name:    "Цвет не найден → " + filepath.Base(bad.Path),
+			When:    Condition{Type: CondNot, Conditions: []Condition{{Type: CondColor}}},
+			Actions: []Action{click(bad)},
+		},
+	}
+}
+
+// iteration caches probe results so that every condition and action of one
+// loop pass sees the same answer without searching twice.
+type iteration struct {
+	frame     frame
+	color     *ColorResult
+	templates map[string]*templateResult
+	screens   screenCache
+}
+
+// templateResult holds the search region cut from the frame and the loaded
+// template of one TemplateRef; the best match and the full match list are
+// computed on demand.
+type templateResult struct {
+	ref      TemplateRef
+	region   image.Rectangle
+	screen   image.Image
+	template *templateImage
+	err      error
+
+	best    *Match
+	all     []Match
+	allDone bool
+}
+
+func newIteration(area image.Rectangle) *iteration {
+	return &iteration{
+		frame:     frame{area: area},
+		templates: make(map[string]*templateResult),
+		screens:   make(screenCache),
+	}
+}
+
+// key identifies ref in the iteration cache. The region goes in with its raw
+// values: String rounds them for display, which would let two regions share
+// one search.
+func (ref TemplateRef) key() string {
+	key := ref.Path + "|" + ref.Mask
+	if s := ref.Region; s != nil {
+		key += fmt.Sprintf("|%g,%g,%g,%g,%t", s.X, s.Y, s.Width, s.Height, s.Relative)
+	}
+	return key
+}
+
+// evalCondition reports whether c holds in the iteration. A probe that
+// cannot be evaluated, because the capture, the template or the search
+// failed, makes the whole condition an error: counting it as not found would
+// make "template_absent" and "not" hold on a broken setup.
+func (r *runner) evalCondition(it *iteration, c Condition) (bool, error) {
+	switch c.Type {
+	case CondAlways:
+		return true, nil
+	case CondColor:
+		res, err := r.colorProbe(it)
+		return res.Found, err
+	case CondTemplate, CondTemplateAbsent:
+		if c.Template == nil {
+			return false, nil
+		}
+		found, err := r.templateProbe(it, *c.Template)
+		if err != nil {
+			return false, err
+		}
+		if c.Type == CondTemplateAbsent {
+			return !found, nil
+		}
+		return found, nil
+	case CondAnd:
+		for _, sub := range c.Conditions {
+			if ok, err := r.evalCondition(it, sub); !ok || err != nil {
+				return false, err
+			}
+		}
+		return len(c.Conditions) > 0, nil
+	case CondOr:
+		for _, sub := range c.Conditions {
+			if ok, err := r.evalCondition(it, sub); ok || err != nil {
+				return ok && err == nil, err
+			}
+		}
+		return false, nil
+	case CondNot:
+		if len(c.Conditions) == 0 {
+			return false, nil
+		}
+		ok, err := r.evalCondition(it, c.Conditions[0])
+		return !ok && err == nil, err
+	default:
+		return false, nil
+	}
+}
@@ -0,0 +1,32 @@
+package automation
+
+import "testing"
+
+func TestTemplateRefKey(t *testing.T) {
+	region := func(x float64) *SearchRegion {
+		return &SearchRegion{X: x, Y: 0.5, Width: 0.25, Height: 0.25, Relative: true}
+	}
+	ref := func(path, mask string, r *SearchRegion) string {
+		return TemplateRef{Path: path, Mask: mask, Region: r}.key()
+	}
+
+	// Regions that print the same still search different areas.
+	if region(0.101).String() != region(0.104).String() {
+		t.Fatalf("%v and %v print differently, the case is not covered", region(0.101), region(0.104))
+	}
+	for _, pair := range [][2]string{
+		{ref("a.png", "", region(0.101)), ref("a.png", "", region(0.104))},
+		{ref("a.png", "", nil), ref("a.png", "", region(0))},
+		{ref("a.png", "", &SearchRegion{X: 10, Width: 5, Height: 5}), ref("a.png", "", &SearchRegion{X: 10, Width: 5, Height: 5, Relative: true})},
+		{ref("a.png", "", nil), ref("a.png", "m.png", nil)},
+		{ref("a.png", "", nil), ref("b.png", "", nil)},
+	} {
+		if pair[0] == pair[1] {
+			t.Errorf("two templates share the key %q", pair[0])
+		}
+	}
+
+	if a, b := ref("a.png", "m.png", region(0.1)), ref("a.png", "m.png", region(0.1)); a != b {
+		t.Errorf("equal templates have the keys %q and %q", a, b)
+	}
+}
@@ -0,0 +1,68 @@
+package automation
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// templateScales lists the template scales to try, from ScaleMin to ScaleMax
+// in ScaleStep increments. Without a usable range only the native size is used.
+func (c Config) templateScales() []float64 {
+	if c.ScaleMin <= 0 || c.ScaleMax < c.ScaleMin || c.ScaleStep <= 0 {
+		return []float64{1.0}
+	}
+
+	var scales []float64
+	for s := c.ScaleMin; s <= c.ScaleMax+1e-9; s += c.ScaleStep {
+		scales = append(scales, math.Round(s*1000)/1000)
+	}
+	return scales
+}
+
+// resizeImage scales img by factor using bilinear interpolation over
+// non-premultiplied colors. The result is a zero-origin *image.NRGBA.
+func resizeImage(img image.Image, factor float64) image.Image {
+	if factor == 1.0 {
+		return img
+	}
+
+	src := img.Bounds()
+	w := max(int(math.Round(float64(src.Dx())*factor)), 1)
+	h := max(int(math.Round(float64(src.Dy())*factor)), 1)
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+
+	fx := float64(src.Dx()) / float64(w)
+	fy := float64(src.Dy()) / float64(h)
+
+	for y := 0; y < h; y++ {
+		sy := math.Max((float64(y)+0.5)*fy-0.5, 0)
+		y0 := min(int(sy), src.Dy()-1)
+		y1 := min(y0+1, src.Dy()-1)
+		wy := sy - float64(y0)
+
+		for x := 0; x < w; x++ {
+			sx := math.Max((float64(x)+0.5)*fx-0.5, 0)
+			x0 := min(int(sx), src.Dx()-1)
+			x1 := min(x0+1, src.Dx()-1)
+			wx := sx - float64(x0)
+
+			var out [4]float64
+			for i, p := range [4]image.Point{{x0, y0}, {x1, y0}, {x0, y1}, {x1, y1}} {
+				weight := [4]float64{(1 - wx) * (1 - wy), wx * (1 - wy), (1 - wx) * wy, wx * wy}[i]
+				c := color.NRGBAModel.Convert(img.At(src.Min.X+p.X, src.Min.Y+p.Y)).(color.NRGBA)
+				out[0] += float64(c.R) * weight
+				out[1] += float64(c.G) * weight
+				out[2] += float64(c.B) * weight
+				out[3] += float64(c.A) * weight
+			}
+
+			off := dst.PixOffset(x, y)
+			for i := 0; i < 4; i++ {
+				dst.Pix[off+i] = uint8(math.Round(out[i]))
+			}
+		}
+	}
+
+	return dst
+}
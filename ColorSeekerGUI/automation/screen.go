@@ -0,0 +1,181 @@
+package automation
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"sync"
+
+	"github.com/kbinani/screenshot"
+)
+
+// ScreenSource supplies frames to the automation loop. Capture must return an
+// image whose Bounds() equal rect, in the same coordinates as Bounds().
+type ScreenSource interface {
+	Bounds() image.Rectangle
+	Capture(rect image.Rectangle) (image.Image, error)
+}
+
+var errNoFrame = errors.New("нет кадра для захвата")
+
+// DisplayAll selects all displays at once, as one virtual desktop.
+const DisplayAll = -1
+
+// LiveScreen captures the real display through the screenshot package, in
+// virtual desktop coordinates: the primary display starts at 0,0 and the
+// others lie around it, possibly at negative coordinates. Display is the
+// index of the display to use, or DisplayAll.
+type LiveScreen struct {
+	Display int
+}
+
+func NewLiveScreen(display int) *LiveScreen {
+	return &LiveScreen{Display: display}
+}
+
+func (s *LiveScreen) Bounds() image.Rectangle {
+	if s.Display == DisplayAll {
+		var bounds image.Rectangle
+		for _, display := range Displays() {
+			bounds = bounds.Union(display)
+		}
+		return bounds
+	}
+	return screenshot.GetDisplayBounds(s.Display)
+}
+
+func (s *LiveScreen) Capture(rect image.Rectangle) (image.Image, error) {
+	if n := screenshot.NumActiveDisplays(); s.Display >= n {
+		return nil, fmt.Errorf("монитор %d не найден, подключено мониторов: %d", s.Display, n)
+	}
+
+	img, err := screenshot.CaptureRect(rect)
+	if err != nil {
+		return nil, err
+	}
+
+	// screenshot returns zero-origin images; shift them back to screen coordinates.
+	img.Rect = rect
+	return img, nil
+}
+
+// Displays returns the bounds of the active displays in virtual desktop
+// coordinates; the index in the slice is the display number of the config.
+func Displays() []image.Rectangle {
+	displays := make([]image.Rectangle, screenshot.NumActiveDisplays())
+	for i := range displays {
+		displays[i] = screenshot.GetDisplayBounds(i)
+	}
+	return displays
+}
+
+// DisplayString describes a display setting for the log.
+func DisplayString(display int) string {
+	if display == DisplayAll {
+		return "все мониторы"
+	}
+	return fmt.Sprintf("монитор %d", display)
+}
+
+// MemoryScreen serves a frame held in memory. The frame can be swapped at any
+// time with SetFrame, which makes it suitable for tests and replays.
+type MemoryScreen struct {
+	mu    sync.Mutex
+	frame image.Image
+}
+
+func NewMemoryScreen(frame image.Image) *MemoryScreen {
+	return &MemoryScreen{frame: frame}
+}
+
+func (s *MemoryScreen) SetFrame(frame image.Image) {
+	s.mu.Lock()
+	s.frame = frame
+	s.mu.Unlock()
+}
+
+func (s *MemoryScreen) Bounds() image.Rectangle {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.frame == nil {
+		return image.Rectangle{}
+	}
+	return s.frame.Bounds()
+}
+
+func (s *MemoryScreen) Capture(rect image.Rectangle) (image.Image, error) {
+	s.mu.Lock()
+	frame := s.frame
+	s.mu.Unlock()
+
+	if frame == nil {
+		return nil, errNoFrame
+	}
+	return cropFrame(frame, rect), nil
+}
+
+// FileScreen replays a sequence of PNG frames. Capture always returns the
+// current frame; Advance moves to the next one, wrapping around at the end.
+// Run advances it after every iteration, so a loop plays the frames in order.
+type FileScreen struct {
+	mu     sync.Mutex
+	paths  []string
+	frames []image.Image
+	index  int
+}
+
+func NewFileScreen(paths ...string) (*FileScreen, error) {
+	if len(paths) == 0 {
+		return nil, errNoFrame
+	}
+
+	frames := make([]image.Image, 0, len(paths))
+	for _, path := range paths {
+		img, err := loadImage(path)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, img)
+	}
+
+	return &FileScreen{paths: paths, frames: frames}, nil
+}
+
+func (s *FileScreen) Advance() {
+	s.mu.Lock()
+	s.index = (s.index + 1) % len(s.frames)
+	s.mu.Unlock()
+}
+
+func (s *FileScreen) Current() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paths[s.index]
+}
+
+func (s *FileScreen) Bounds() image.Rectangle {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.frames[s.index].Bounds()
+}
+
+func (s *FileScreen) Capture(rect image.Rectangle) (image.Image, error) {
+	s.mu.Lock()
+	frame := s.frames[s.index]
+	s.mu.Unlock()
+
+	return cropFrame(frame, rect), nil
+}
+
+// advancer is a ScreenSource that steps through recorded frames.
+type advancer interface {
+	Advance()
+}
+
+func cropFrame(frame image.Image, rect image.Rectangle) image.Image {
+	dst := image.NewRGBA(rect)
+	draw.Draw(dst, rect, frame, rect.Min, draw.Src)
+	return dst
+}
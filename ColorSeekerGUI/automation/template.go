@@ -0,0 +1,185 @@
+package automation
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// templateImage is a template prepared for matching: a zero-origin,
+// non-premultiplied copy of the PNG plus a mask of the pixels that count
+// towards the score. Pixels with alpha 0, or black/transparent in a separate
+// mask PNG, are left out of both the sum and the normalization denominator.
+// sum is the per-channel sum over the counted pixels and sumSq the sum of
+// their squared channels, which NCC would otherwise recompute for every
+// candidate.
+type templateImage struct {
+	img   *image.NRGBA
+	mask  []bool
+	count int
+	sum   [3]int64
+	sumSq int64
+}
+
+var errEmptyMask = errors.New("в шаблоне нет ни одного непрозрачного пикселя")
+
+func loadTemplate(path, maskPath string) (*templateImage, error) {
+	img, err := loadImage(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var maskImg image.Image
+	if maskPath != "" {
+		maskImg, err = loadImage(maskPath)
+		if err != nil {
+			return nil, err
+		}
+		if maskImg.Bounds().Size() != img.Bounds().Size() {
+			return nil, fmt.Errorf("размер маски %s (%v) не совпадает с размером шаблона (%v)",
+				maskPath, maskImg.Bounds().Size(), img.Bounds().Size())
+		}
+	}
+
+	return prepareTemplate(img, maskImg)
+}
+
+func prepareTemplate(img image.Image, maskImg image.Image) (*templateImage, error) {
+	b := img.Bounds()
+	nrgba := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(nrgba, nrgba.Rect, img, b.Min, draw.Src)
+
+	mask := make([]bool, b.Dx()*b.Dy())
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			keep := nrgba.Pix[nrgba.PixOffset(x, y)+3] != 0
+			if keep && maskImg != nil {
+				m := color.GrayModel.Convert(maskImg.At(maskImg.Bounds().Min.X+x, maskImg.Bounds().Min.Y+y)).(color.Gray)
+				keep = m.Y >= 128
+			}
+			mask[y*b.Dx()+x] = keep
+		}
+	}
+
+	t := newTemplateImage(nrgba, mask)
+	if t.count == 0 {
+		return nil, errEmptyMask
+	}
+	return t, nil
+}
+
+// newTemplateImage counts the pixels kept by mask and sums them up. A mask
+// that keeps everything is dropped, so the match loops can skip the checks.
+func newTemplateImage(img *image.NRGBA, mask []bool) *templateImage {
+	t := &templateImage{img: img, mask: mask}
+	size := t.Size()
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			if !t.counts(x, y) {
+				continue
+			}
+			t.count++
+			r, g, b := t.rgb(x, y)
+			t.sum[0] += int64(r)
+			t.sum[1] += int64(g)
+			t.sum[2] += int64(b)
+			t.sumSq += int64(r*r + g*g + b*b)
+		}
+	}
+
+	if t.count == len(mask) {
+		t.mask = nil
+	}
+	return t
+}
+
+func (t *templateImage) Size() image.Point {
+	return t.img.Rect.Size()
+}
+
+func (t *templateImage) counts(x, y int) bool {
+	return t.mask == nil || t.mask[y*t.img.Rect.Dx()+x]
+}
+
+func (t *templateImage) rgb(x, y int) (int, int, int) {
+	off := t.img.PixOffset(x, y)
+	return int(t.img.Pix[off]), int(t.img.Pix[off+1]), int(t.img.Pix[off+2])
+}
+
+// row returns the pixels of row y and its mask, nil when every pixel counts.
+func (t *templateImage) row(y int) ([]uint8, []bool) {
+	w := t.img.Rect.Dx()
+	pix := t.img.Pix[y*t.img.Stride : y*t.img.Stride+w*4]
+	if t.mask == nil {
+		return pix, nil
+	}
+	return pix, t.mask[y*w : (y+1)*w]
+}
+
+// half is the template at half the size for a pyramid level, filtered like
+// pixels.half. Pixels that do not count are left out of the filter; a pixel
+// counts when the ones that do carry at least half the kernel's weight.
+func (t *templateImage) half() *templateImage {
+	size := t.Size()
+	w, h := size.X/2, size.Y/2
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	mask := make([]bool, w*h)
+	kernel := [4]int{1, 3, 3, 1}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var sum [3]int
+			weight := 0
+			for j, ky := range kernel {
+				sy := min(max(2*y-1+j, 0), size.Y-1)
+				for i, kx := range kernel {
+					sx := min(max(2*x-1+i, 0), size.X-1)
+					if !t.counts(sx, sy) {
+						continue
+					}
+					k := kx * ky
+					r, g, b := t.rgb(sx, sy)
+					sum[0], sum[1], sum[2] = sum[0]+k*r, sum[1]+k*g, sum[2]+k*b
+					weight += k
+				}
+			}
+			if weight < 32 {
+				continue
+			}
+			off := img.PixOffset(x, y)
+			for c := 0; c < 3; c++ {
+				img.Pix[off+c] = uint8((sum[c] + weight/2) / weight)
+			}
+			img.Pix[off+3] = 255
+			mask[y*w+x] = true
+		}
+	}
+	return newTemplateImage(img, mask)
+}
+
+// scaled resizes the template; the mask is resampled with nearest neighbour
+// so that it stays binary.
+func (t *templateImage) scaled(factor float64) *templateImage {
+	if factor == 1.0 {
+		return t
+	}
+
+	img := resizeImage(t.img, factor).(*image.NRGBA)
+	if t.mask == nil {
+		return newTemplateImage(img, nil)
+	}
+
+	src := t.Size()
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	mask := make([]bool, w*h)
+	for y := 0; y < h; y++ {
+		sy := min(y*src.Y/h, src.Y-1)
+		for x := 0; x < w; x++ {
+			sx := min(x*src.X/w, src.X-1)
+			mask[y*w+x] = t.mask[sy*src.X+sx]
+		}
+	}
+
+	return newTemplateImage(img, mask)
+}
@@ -0,0 +1,237 @@
+package automation
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Validate reports every problem of the configuration that would make the
+// loop misbehave: out of range settings, unknown condition and action types,
+// actions without the fields they need and template files that do not exist.
+// The result joins all problems, or is nil.
+func (c Config) Validate() error {
+	v := &validator{}
+
+	if c.LoopDelay < 0 {
+		v.add("", "loop_delay_seconds не может быть отрицательным")
+	}
+	if c.MatchThreshold < 0 || c.MatchThreshold > 1 {
+		v.add("", "match_threshold должен быть от 0 до 1, указано %g", c.MatchThreshold)
+	}
+	if c.PyramidLevels < 0 {
+		v.add("", "pyramid_levels не может быть отрицательным, указано %d", c.PyramidLevels)
+	}
+	if c.PyramidCandidates < 1 {
+		v.add("", "pyramid_candidates должен быть не меньше 1, указано %d", c.PyramidCandidates)
+	}
+	if !oneOf(c.MatchMetric, "", MetricSAD, MetricSSD, MetricNCC) {
+		v.add("", "неизвестная метрика match_metric: %q", c.MatchMetric)
+	}
+	if c.ScaleMin <= 0 || c.ScaleMax < c.ScaleMin || c.ScaleStep <= 0 {
+		v.add("", "неверный диапазон масштабов: %g-%g, шаг %g", c.ScaleMin, c.ScaleMax, c.ScaleStep)
+	}
+	if c.MatchOverlap < 0 || c.MatchOverlap > 1 {
+		v.add("", "match_overlap должен быть от 0 до 1, указано %g", c.MatchOverlap)
+	}
+	if !oneOf(c.ColorCondition, "", ColorAny, ColorCount, ColorPercent) {
+		v.add("", "неизвестное условие цвета color_condition: %q", c.ColorCondition)
+	}
+	if !oneOf(c.ColorModel, "", ColorModelRGB, ColorModelEuclidean, ColorModelCIE76, ColorModelCIEDE2000, ColorModelHSV) {
+		v.add("", "неизвестная модель цвета color_model: %q", c.ColorModel)
+	}
+	if c.TargetColor > 0xFFFFFF {
+		v.add("", "target_color вне диапазона RGB: %#x", c.TargetColor)
+	}
+	if c.Display < DisplayAll {
+		v.add("", "display: номер монитора от 0 или %d для всех мониторов, указано %d", DisplayAll, c.Display)
+	}
+	if !oneOf(c.MatchOrder, "", OrderRaster, OrderContrast) {
+		v.add("", "неизвестный порядок обхода match_order: %q", c.MatchOrder)
+	}
+	if c.SearchWorkers < 0 {
+		v.add("", "search_workers не может быть отрицательным, 0 — по числу ядер")
+	}
+	if c.APIListen != "" {
+		if err := CheckLoopback(c.APIListen); err != nil {
+			v.add("", "api_listen: %v", err)
+		}
+	}
+
+	if len(c.Rules) == 0 {
+		v.add("", "не задано ни одного правила")
+	}
+	for i, rule := range c.Rules {
+		where := fmt.Sprintf("правило %d", i+1)
+		if rule.Name != "" {
+			where += fmt.Sprintf(" (%s)", rule.Name)
+		}
+
+		v.condition(where+", условие", rule.When)
+		if len(rule.Actions) == 0 {
+			v.add(where, "нет действий")
+		}
+		for j, action := range rule.Actions {
+			v.action(fmt.Sprintf("%s, действие %d", where, j+1), action)
+		}
+	}
+
+	return errors.Join(v.errs...)
+}
+
+// Problems splits an error returned by Validate into its messages. It returns
+// an empty slice for nil.
+func Problems(err error) []string {
+	if err == nil {
+		return []string{}
+	}
+	var joined interface{ Unwrap() []error }
+	if errors.As(err, &joined) {
+		var messages []string
+		for _, e := range joined.Unwrap() {
+			messages = append(messages, e.Error())
+		}
+		return messages
+	}
+	return strings.Split(err.Error(), "\n")
+}
+
+// CheckLoopback accepts only a host:port on the local machine: the control
+// API has no authentication, so it must not be reachable from the network.
+// Listening on loopback alone does not keep out web pages open in a browser
+// on the same machine, so the API also checks every request's Host and
+// Origin with LoopbackHost (see api.Server.Handler).
+func CheckLoopback(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("неверный адрес %q: нужен вид 127.0.0.1:8765", addr)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf("неверный порт в адресе %q", addr)
+	}
+	if !LoopbackHost(host) {
+		return fmt.Errorf("адрес %q не локальный: разрешены только localhost, 127.0.0.1 и ::1", addr)
+	}
+	return nil
+}
+
+// LoopbackHost reports whether host, without a port, names the local
+// machine: localhost or a loopback IP, which may be in brackets.
+func LoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+type validator struct {
+	errs []error
+}
+
+func (v *validator) add(where, format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	if where != "" {
+		message = where + ": " + message
+	}
+	v.errs = append(v.errs, errors.New(message))
+}
+
+func (v *validator) condition(where string, c Condition) {
+	switch c.Type {
+	case CondAlways, CondColor:
+	case CondTemplate, CondTemplateAbsent:
+		v.template(where, c.Template)
+	case CondAnd, CondOr:
+		if len(c.Conditions) == 0 {
+			v.add(where, "%s без вложенных условий", c.Type)
+		}
+	case CondNot:
+		if len(c.Conditions) != 1 {
+			v.add(where, "not требует ровно одно вложенное условие, указано %d", len(c.Conditions))
+		}
+	default:
+		v.add(where, "неизвестный тип условия %q", c.Type)
+		return
+	}
+
+	for i, sub := range c.Conditions {
+		v.condition(fmt.Sprintf("%s.%d", where, i+1), sub)
+	}
+}
+
+func (v *validator) action(where string, a Action) {
+	switch a.Type {
+	case ActionClick, ActionHold, ActionDrag:
+		v.template(where, a.Template)
+	case ActionScroll:
+		if a.Template != nil {
+			v.template(where, a.Template)
+		}
+	case ActionKey:
+		if key, _ := a.chord(); key == "" {
+			v.add(where, "не указана клавиша")
+		}
+	case ActionType, ActionWait:
+	default:
+		v.add(where, "неизвестный тип действия %q", a.Type)
+		return
+	}
+
+	if a.Type == ActionDrag {
+		v.template(where+", to", a.To)
+	}
+	if a.DurationMs < 0 {
+		v.add(where, "duration_ms не может быть отрицательным")
+	}
+	if !oneOf(a.Select, "", ClickBest, ClickFirst, ClickLast, ClickNearest, ClickTopmost, ClickAll) {
+		v.add(where, "неизвестный выбор совпадения select: %q", a.Select)
+	}
+	if !oneOf(a.Anchor, "", AnchorCenter, AnchorTopLeft, AnchorTop, AnchorTopRight, AnchorLeft,
+		AnchorRight, AnchorBottomLeft, AnchorBottom, AnchorBottomRight) {
+		v.add(where, "неизвестный якорь anchor: %q", a.Anchor)
+	}
+
+	if a.Verify != nil {
+		if a.Verify.Retries < 0 || a.Verify.DelayMs < 0 || a.Verify.BackoffMs < 0 {
+			v.add(where, "verify: значения не могут быть отрицательными")
+		}
+		if a.Verify.Expect != nil {
+			v.condition(where+", verify.expect", *a.Verify.Expect)
+		} else if a.Template == nil {
+			v.add(where, "verify: без шаблона действия нужно условие expect")
+		}
+	}
+}
+
+func (v *validator) template(where string, ref *TemplateRef) {
+	if ref == nil || ref.Path == "" {
+		v.add(where, "не указан шаблон")
+		return
+	}
+
+	if _, err := os.Stat(ref.Path); err != nil {
+		v.add(where, "шаблон недоступен: %v", err)
+	}
+	if ref.Mask != "" {
+		if _, err := os.Stat(ref.Mask); err != nil {
+			v.add(where, "маска недоступна: %v", err)
+		}
+	}
+	if r := ref.Region; r != nil && (r.Width <= 0 || r.Height <= 0) {
+		v.add(where, "пустая область поиска %s", r)
+	}
+}
+
+func oneOf(value string, options ...string) bool {
+	for _, option := range options {
+		if value == option {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package automation
+
+import (
+	"math"
+	"time"
+)
+
+// Verify checks that an action had an effect. DelayMs after the action the
+// Expect condition is evaluated on a fresh capture; without Expect the clicked
+// template must be gone. If the check fails the action is repeated up to
+// Retries times, waiting BackoffMs before the first retry and BackoffFactor
+// times longer before every next one.
+type Verify struct {
+	DelayMs       int        `json:"delay_ms"`
+	Expect        *Condition `json:"expect,omitempty"`
+	Retries       int        `json:"retries"`
+	BackoffMs     int        `json:"backoff_ms"`
+	BackoffFactor float64    `json:"backoff_factor,omitempty"`
+}
+
+func (v Verify) expect(action Action) (Condition, bool) {
+	if v.Expect != nil {
+		return *v.Expect, true
+	}
+	if action.Template == nil {
+		return Condition{}, false
+	}
+	return Condition{Type: CondTemplateAbsent, Template: action.Template}, true
+}
+
+// backoff returns the pause before the given retry, counting from 1.
+func (v Verify) backoff(retry int) time.Duration {
+	factor := v.BackoffFactor
+	if factor <= 0 {
+		factor = 1
+	}
+	ms := float64(v.BackoffMs) * math.Pow(factor, float64(retry-1))
+	return time.Duration(ms) * time.Millisecond
+}
+
+// verify waits for the effect of action and repeats the action while it is
+// missing. Each check starts a new iteration with a frame of its own, so that
+// nothing captured before the click is reused.
+func (r *runner) verify(action Action) bool {
+	v := *action.Verify
+	expect, ok := v.expect(action)
+	if !ok {
+		r.emit(&VerifyResult{Action: action, Final: true, Skipped: true})
+		return true
+	}
+
+	attempts := max(v.Retries, 0) + 1
+	for attempt := 1; ; attempt++ {
+		if !r.sleep(time.Duration(v.DelayMs) * time.Millisecond) {
+			return false
+		}
+
+		// A check that cannot be evaluated has not passed: nothing shows
+		// that the action had its effect.
+		result := &VerifyResult{Action: action, Expect: expect, Attempt: attempt, Attempts: attempts}
+		passed, err := r.evalCondition(r.checkIteration(expect), expect)
+		if r.ctx.Err() != nil {
+			return false
+		}
+		result.Passed = passed && err == nil
+		if err != nil {
+			result.Err = err.Error()
+		}
+		result.Final = result.Passed || attempt == attempts
+		if !result.Final {
+			result.Retry = v.backoff(attempt)
+		}
+		r.emit(result)
+
+		if result.Final {
+			return result.Passed
+		}
+		if !r.sleep(result.Retry) {
+			return false
+		}
+
+		// The target may have moved, so search for it again. If the action
+		// cannot be repeated the next check still decides the outcome.
+		r.perform(r.actionIteration(action), action)
+	}
+}
@@ -0,0 +1,128 @@
+package automation
+
+import (
+	"context"
+	"image"
+	"testing"
+	"time"
+)
+
+// clickSwaps is an actuator that shows the next frame on the screen when it
+// clicks, as a real click changes what is displayed.
+type clickSwaps struct {
+	*RecordingActuator
+	screen *MemoryScreen
+	next   image.Image
+}
+
+func (a *clickSwaps) Click(button string, double bool) {
+	a.RecordingActuator.Click(button, double)
+	a.screen.SetFrame(a.next)
+}
+
+// verifyRun clicks Good.png on frame once with verify and returns the verify
+// results and the clicks made. After each click the screen shows next.
+func verifyRun(t *testing.T, v Verify, frame, next image.Image) ([]*VerifyResult, []RecordedAction) {
+	t.Helper()
+	config := loopConfig()
+	ref := TemplateRef{Path: "../Good.png"}
+	config.Rules = []Rule{{
+		When:    Condition{Type: CondAlways},
+		Actions: []Action{{Type: ActionClick, Template: &ref, Verify: &v}},
+	}}
+
+	screen := NewMemoryScreen(frame)
+	input := &clickSwaps{RecordingActuator: NewRecordingActuator(), screen: screen, next: next}
+	bus := NewBus()
+	events := bus.Subscribe(1024, DropOldest)
+	RunOnce(context.Background(), config, bus, WithScreen(screen), WithActuator(input))
+	bus.Close()
+
+	var results []*VerifyResult
+	for e := range events.Events() {
+		if result, ok := e.(*VerifyResult); ok {
+			results = append(results, result)
+		}
+	}
+	return results, input.Clicks()
+}
+
+func TestVerifyBackoff(t *testing.T) {
+	v := Verify{BackoffMs: 100, BackoffFactor: 2}
+	for retry, want := range []time.Duration{100, 200, 400, 800} {
+		if got := v.backoff(retry + 1); got != want*time.Millisecond {
+			t.Errorf("backoff before retry %d = %v, want %v", retry+1, got, want*time.Millisecond)
+		}
+	}
+	if got := (Verify{BackoffMs: 50}).backoff(3); got != 50*time.Millisecond {
+		t.Errorf("backoff without a factor = %v, want 50ms", got)
+	}
+}
+
+func TestVerifyRetriesUntilGivenUp(t *testing.T) {
+	// The template stays on screen, so every check fails: the click is
+	// repeated Retries times with growing pauses, then given up as an error.
+	frame, _, _ := loopScreen(t, loopConfig(), true, image.Pt(60, 80), image.Pt(250, 160))
+	results, clicks := verifyRun(t, Verify{Retries: 3, BackoffMs: 5, BackoffFactor: 2}, frame, frame)
+
+	if len(clicks) != 4 {
+		t.Errorf("clicked %d times, want 4", len(clicks))
+	}
+	if len(results) != 4 {
+		t.Fatalf("%d verify results, want 4", len(results))
+	}
+	for i, result := range results {
+		final := i == 3
+		if result.Passed || result.Attempt != i+1 || result.Attempts != 4 || result.Final != final {
+			t.Errorf("result %d: %+v", i, result)
+		}
+		if want := time.Duration(5<<i) * time.Millisecond; !final && result.Retry != want {
+			t.Errorf("result %d: retry after %v, want %v", i, result.Retry, want)
+		}
+	}
+	if level := results[3].Level(); level != LevelError {
+		t.Errorf("final result at level %s, want %s", level, LevelError)
+	}
+}
+
+func TestVerifyPassesWhenTemplateGone(t *testing.T) {
+	frame, _, _ := loopScreen(t, loopConfig(), true, image.Pt(60, 80), image.Pt(250, 160))
+	blank := image.NewRGBA(frame.Bounds())
+	results, clicks := verifyRun(t, Verify{Retries: 3, BackoffMs: 5}, frame, blank)
+
+	if len(clicks) != 1 || len(results) != 1 {
+		t.Fatalf("%d clicks and %d verify results, want 1 and 1", len(clicks), len(results))
+	}
+	if r := results[0]; !r.Passed || !r.Final || r.Level() != LevelSuccess {
+		t.Errorf("result %+v at level %s, want passed", r, r.Level())
+	}
+}
+
+func TestVerifyCheckErrorDoesNotPass(t *testing.T) {
+	// A check that cannot be evaluated is not evidence that the click
+	// worked, whether the template is missing or the capture fails.
+	frame, _, _ := loopScreen(t, loopConfig(), true, image.Pt(60, 80), image.Pt(250, 160))
+	missing := &Condition{Type: CondTemplateAbsent, Template: &TemplateRef{Path: "missing.png"}}
+
+	for _, tc := range []struct {
+		name   string
+		verify Verify
+		next   image.Image
+	}{
+		{"missing template", Verify{Expect: missing, Retries: 1, BackoffMs: 5}, frame},
+		{"no frame", Verify{Retries: 1, BackoffMs: 5}, nil},
+	} {
+		results, _ := verifyRun(t, tc.verify, frame, tc.next)
+		if len(results) != 2 {
+			t.Fatalf("%s: %d verify results, want 2", tc.name, len(results))
+		}
+		for _, r := range results {
+			if r.Passed || r.Err == "" {
+				t.Errorf("%s: result %+v, want failed with an error", tc.name, r)
+			}
+		}
+		if level := results[1].Level(); !results[1].Final || level != LevelError {
+			t.Errorf("%s: last result at level %s, want a final %s", tc.name, level, LevelError)
+		}
+	}
+}
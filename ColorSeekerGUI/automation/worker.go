@@ -9,46 +9,86 @@ import (
 	"log"
 	"os"
 	"time"
-
-	"github.com/go-vgo/robotgo"
-	"github.com/kbinani/screenshot"
 )
 
-type Status struct {
-	Timestamp time.Time
-	Message   string
-	Level     string
-}
-
 type Config struct {
-	ColorX1        int     `json:"color_x1"`
-	ColorY1        int     `json:"color_y1"`
-	ColorX2        int     `json:"color_x2"`
-	ColorY2        int     `json:"color_y2"`
-	TargetColor    uint32  `json:"target_color"`
-	ShadeVariation int     `json:"shade_variation"`
-	GoodImagePath  string  `json:"good_image_path"`
-	BadImagePath   string  `json:"bad_image_path"`
-	LoopDelay      int     `json:"loop_delay_seconds"`
-	MatchThreshold float64 `json:"match_threshold"`
-	SearchScale    int     `json:"search_scale"`
-	RefineRadius   int     `json:"refine_radius"`
+	ColorX1           int     `json:"color_x1"`
+	ColorY1           int     `json:"color_y1"`
+	ColorX2           int     `json:"color_x2"`
+	ColorY2           int     `json:"color_y2"`
+	TargetColor       uint32  `json:"target_color"`
+	ShadeVariation    int     `json:"shade_variation"`
+	LoopDelay         int     `json:"loop_delay_seconds"`
+	MatchThreshold    float64 `json:"match_threshold"`
+	PyramidLevels     int     `json:"pyramid_levels"`
+	PyramidCandidates int     `json:"pyramid_candidates"`
+	MatchMetric       string  `json:"match_metric"`
+	ScaleMin          float64 `json:"scale_min"`
+	ScaleMax          float64 `json:"scale_max"`
+	ScaleStep         float64 `json:"scale_step"`
+	MaxMatches        int     `json:"max_matches"`
+	MatchOverlap      float64 `json:"match_overlap"`
+
+	ColorCondition  string  `json:"color_condition"`
+	ColorMinPixels  int     `json:"color_min_pixels"`
+	ColorMinPercent float64 `json:"color_min_percent"`
+	ColorModel      string  `json:"color_model"`
+	ColorDistance   float64 `json:"color_distance"`
+	DeltaE          float64 `json:"delta_e"`
+	HueMin          float64 `json:"hue_min"`
+	HueMax          float64 `json:"hue_max"`
+	SatMin          float64 `json:"sat_min"`
+	SatMax          float64 `json:"sat_max"`
+	ValMin          float64 `json:"val_min"`
+	ValMax          float64 `json:"val_max"`
+
+	Rules []Rule `json:"rules"`
+
+	APIListen string `json:"api_listen,omitempty"`
+
+	Display int `json:"display"`
+
+	SearchWorkers int `json:"search_workers"`
+
+	MatchEarlyExit bool   `json:"match_early_exit"`
+	MatchOrder     string `json:"match_order"`
 }
 
 func DefaultConfig() Config {
 	return Config{
-		ColorX1:        11,
-		ColorY1:        420,
-		ColorX2:        11,
-		ColorY2:        440,
-		TargetColor:    0x77604B,
-		ShadeVariation: 10,
-		GoodImagePath:  "Good.png",
-		BadImagePath:   "bad.png",
-		LoopDelay:      1,
-		MatchThreshold: 0.80,
-		SearchScale:    16,
-		RefineRadius:   24,
+		ColorX1:           11,
+		ColorY1:           420,
+		ColorX2:           11,
+		ColorY2:           440,
+		TargetColor:       0x77604B,
+		ShadeVariation:    10,
+		LoopDelay:         1,
+		MatchThreshold:    0.80,
+		PyramidLevels:     3,
+		PyramidCandidates: 10,
+		MatchMetric:       MetricSAD,
+		ScaleMin:          1.0,
+		ScaleMax:          1.0,
+		ScaleStep:         0.25,
+		MaxMatches:        10,
+		MatchOverlap:      0.3,
+
+		ColorCondition:  ColorAny,
+		ColorMinPixels:  1,
+		ColorMinPercent: 50,
+		ColorModel:      ColorModelRGB,
+		ColorDistance:   17,
+		DeltaE:          5,
+		HueMin:          0,
+		HueMax:          360,
+		SatMin:          0,
+		SatMax:          100,
+		ValMin:          0,
+		ValMax:          100,
+
+		Rules: legacyConfig{}.rules(),
+
+		MatchOrder: OrderRaster,
 	}
 }
 
@@ -58,11 +98,21 @@ func LoadConfig(filename string) (Config, error) {
 		return DefaultConfig(), err
 	}
 
-	var config Config
+	config := DefaultConfig()
+	config.Rules = nil
 	if err := json.Unmarshal(data, &config); err != nil {
 		return DefaultConfig(), err
 	}
 
+	if len(config.Rules) == 0 {
+		var legacy legacyConfig
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			return DefaultConfig(), err
+		}
+		config.Rules = legacy.rules()
+		log.Printf("Конфигурация %s переведена на правила (%d шт.)", filename, len(config.Rules))
+	}
+
 	return config, nil
 }
 
@@ -75,251 +125,309 @@ func (c *Config) Save(filename string) error {
 	return os.WriteFile(filename, data, 0644)
 }
 
-func Run(ctx context.Context, config Config, statusChan chan<- Status) {
+type Option func(*runner)
+
+func WithScreen(screen ScreenSource) Option {
+	return func(r *runner) {
+		r.screen = screen
+	}
+}
+
+func WithActuator(input Actuator) Option {
+	return func(r *runner) {
+		r.input = input
+	}
+}
+
+type runner struct {
+	ctx       context.Context
+	config    Config
+	screen    ScreenSource
+	input     Actuator
+	bus       *Bus
+	iteration int
+	failure   error // the first error-level event, for RunOnce
+
+	// paused is set by Engine and returns a channel closed on resume, or nil
+	// while the loop may run.
+	paused func() <-chan struct{}
+}
+
+// Run loops over the rules until ctx is cancelled, publishing what happens
+// on bus. A nil bus discards the events.
+func Run(ctx context.Context, config Config, bus *Bus, opts ...Option) {
+	r := newRunner(ctx, config, bus, opts)
+	r.emit(&EngineStateChanged{State: StateRunning})
+	r.loop(ctx)
+	r.emit(&EngineStateChanged{State: StateStopped})
+}
+
+// RunOnce evaluates the rules a single time, as one iteration of Run does.
+// It returns the first error the iteration reported: a failed capture,
+// template or action, or an action its verify check gave up on.
+func RunOnce(ctx context.Context, config Config, bus *Bus, opts ...Option) error {
+	r := newRunner(ctx, config, bus, opts)
+	r.iterate(1)
+	return r.failure
+}
+
+func newRunner(ctx context.Context, config Config, bus *Bus, opts []Option) *runner {
+	r := &runner{
+		ctx:    ctx,
+		config: config,
+		screen: NewLiveScreen(config.Display),
+		input:  NewRobotActuator(),
+		bus:    bus,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// sleep waits for d and reports false if the run was cancelled meanwhile.
+func (r *runner) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-r.ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func (r *runner) loop(ctx context.Context) {
 	iteration := 0
 
 	for {
 		select {
 		case <-ctx.Done():
-			statusChan <- Status{
-				Timestamp: time.Now(),
-				Message:   "Автоматизация остановлена",
-				Level:     "info",
-			}
+			r.iteration = 0
 			return
 		default:
-			iteration++
-			statusChan <- Status{
-				Timestamp: time.Now(),
-				Message:   fmt.Sprintf("=== Итерация #%d ===", iteration),
-				Level:     "info",
+			if !r.waitResume() {
+				continue
 			}
-
-			foundColor, foundY := findColorInArea(config, statusChan)
-
-			if foundColor {
-				statusChan <- Status{
-					Timestamp: time.Now(),
-					Message:   fmt.Sprintf("✓ Цвет #%06X найден на Y=%d", config.TargetColor, foundY),
-					Level:     "success",
-				}
-				statusChan <- Status{
-					Timestamp: time.Now(),
-					Message:   fmt.Sprintf("→ Ищу изображение: %s", config.GoodImagePath),
-					Level:     "info",
-				}
-				if findAndClickImage(config.GoodImagePath, config, statusChan) {
-					statusChan <- Status{
-						Timestamp: time.Now(),
-						Message:   fmt.Sprintf("✓ Изображение %s найдено и кликнуто", config.GoodImagePath),
-						Level:     "success",
-					}
-				} else {
-					statusChan <- Status{
-						Timestamp: time.Now(),
-						Message:   fmt.Sprintf("✗ Изображение %s не найдено", config.GoodImagePath),
-						Level:     "error",
-					}
-				}
-			} else {
-				statusChan <- Status{
-					Timestamp: time.Now(),
-					Message:   fmt.Sprintf("✗ Цвет #%06X не найден", config.TargetColor),
-					Level:     "warning",
-				}
-				statusChan <- Status{
-					Timestamp: time.Now(),
-					Message:   fmt.Sprintf("→ Ищу изображение: %s", config.BadImagePath),
-					Level:     "info",
-				}
-				if findAndClickImage(config.BadImagePath, config, statusChan) {
-					statusChan <- Status{
-						Timestamp: time.Now(),
-						Message:   fmt.Sprintf("✓ Изображение %s найдено и кликнуто", config.BadImagePath),
-						Level:     "success",
-					}
-				} else {
-					statusChan <- Status{
-						Timestamp: time.Now(),
-						Message:   fmt.Sprintf("✗ Изображение %s не найдено", config.BadImagePath),
-						Level:     "error",
-					}
-				}
+			iteration++
+			r.iterate(iteration)
+			if replay, ok := r.screen.(advancer); ok {
+				replay.Advance()
 			}
-
-			time.Sleep(time.Duration(config.LoopDelay) * time.Second)
+			r.sleep(time.Duration(r.config.LoopDelay) * time.Second)
 		}
 	}
 }
 
-func findColorInArea(config Config, statusChan chan<- Status) (bool, int) {
-	bounds := screenshot.GetDisplayBounds(0)
-	img, err := screenshot.CaptureRect(bounds)
-	if err != nil {
-		statusChan <- Status{
-			Timestamp: time.Now(),
-			Message:   fmt.Sprintf("Ошибка захвата экрана: %v", err),
-			Level:     "error",
-		}
-		return false, 0
+// waitResume blocks while the engine is paused. It reports false if the run
+// was cancelled meanwhile.
+func (r *runner) waitResume() bool {
+	if r.paused == nil {
+		return true
+	}
+	resume := r.paused()
+	if resume == nil {
+		return true
 	}
 
-	targetR, targetG, targetB := hexToRGB(config.TargetColor)
+	select {
+	case <-resume:
+		return true
+	case <-r.ctx.Done():
+		return false
+	}
+}
 
-	for y := config.ColorY1; y <= config.ColorY2; y++ {
-		x := config.ColorX1
-		if x < bounds.Min.X || x >= bounds.Max.X || y < bounds.Min.Y || y >= bounds.Max.Y {
+// iterate evaluates the rules once and runs the first matching one. A
+// condition that cannot be evaluated ends the iteration: a later rule must
+// not run in place of one that might have held. The probe has reported the
+// error already.
+func (r *runner) iterate(n int) {
+	r.iteration = n
+	r.emit(&IterationStarted{})
+
+	it := r.newIteration()
+	for i, rule := range r.config.Rules {
+		ok, err := r.evalCondition(it, rule.When)
+		if err != nil {
+			return
+		}
+		if !ok {
 			continue
 		}
 
-		pixelColor := img.At(x, y)
-		r, g, b, _ := pixelColor.RGBA()
-
-		r8 := uint8(r >> 8)
-		g8 := uint8(g >> 8)
-		b8 := uint8(b >> 8)
+		r.emit(&RuleMatched{Index: i, Name: rule.Name})
+		r.runActions(it, rule.Actions)
+		return
+	}
+}
 
-		if colorMatch(r8, g8, b8, targetR, targetG, targetB, config.ShadeVariation) {
-			return true, y
-		}
+func (r *runner) findColorInArea(it *iteration) (ColorResult, error) {
+	img, err := r.frame(it)
+	if err != nil {
+		return ColorResult{}, err
 	}
 
-	return false, 0
+	return scanColor(img, r.config), nil
 }
 
-func findAndClickImage(imagePath string, config Config, statusChan chan<- Status) bool {
-	bounds := screenshot.GetDisplayBounds(0)
-	screen, err := screenshot.CaptureRect(bounds)
-	if err != nil {
-		log.Printf("Ошибка захвата экрана: %v\n", err)
-		return false
+func (r *runner) colorProbe(it *iteration) (ColorResult, error) {
+	if it.color != nil {
+		return *it.color, nil
 	}
 
-	template, err := loadImage(imagePath)
+	config := r.config
+	start := time.Now()
+	colorResult, err := r.findColorInArea(it)
 	if err != nil {
-		log.Printf("Ошибка загрузки изображения %s: %v\n", imagePath, err)
-		return false
+		return ColorResult{}, err
 	}
+	it.color = &colorResult
+
+	r.emit(&ColorProbeResult{
+		ColorResult: colorResult,
+		Target:      config.TargetColor,
+		Rect:        config.colorRect(),
+		Condition:   config.ColorCondition,
+		MinPixels:   config.ColorMinPixels,
+		MinPercent:  config.ColorMinPercent,
+		Duration:    time.Since(start),
+	})
+
+	return colorResult, nil
+}
 
-	loc, confidence := templateMatch(screen, template, config.SearchScale, config.RefineRadius)
-	if confidence >= config.MatchThreshold {
-		centerX := loc.X + template.Bounds().Dx()/2
-		centerY := loc.Y + template.Bounds().Dy()/2
-
-		robotgo.Move(centerX, centerY)
-		time.Sleep(50 * time.Millisecond)
-		robotgo.Click()
-
-		statusChan <- Status{
-			Timestamp: time.Now(),
-			Message:   fmt.Sprintf("  Клик: X=%d, Y=%d (точность: %.0f%%)", centerX, centerY, confidence*100),
-			Level:     "info",
-		}
+// templateProbe reports whether ref is found, or why it could not be
+// searched for.
+func (r *runner) templateProbe(it *iteration, ref TemplateRef) (bool, error) {
+	_, ok := r.bestMatch(it, ref)
+	return ok, r.searchTemplate(it, ref).err
+}
 
-		return true
+// searchTemplate cuts the search region of ref from the iteration's frame and
+// loads its template, once per iteration.
+func (r *runner) searchTemplate(it *iteration, ref TemplateRef) *templateResult {
+	if res, ok := it.templates[ref.key()]; ok {
+		return res
 	}
 
-	return false
-}
+	res := &templateResult{ref: ref}
+	it.templates[ref.key()] = res
 
-func loadImage(path string) (image.Image, error) {
-	file, err := os.Open(path)
+	// The frame first: without a screen its capture error says why, not
+	// the region.
+	img, err := r.frame(it)
 	if err != nil {
-		return nil, err
+		res.err = err
+		return res
 	}
-	defer file.Close()
 
-	img, err := png.Decode(file)
-	if err != nil {
-		return nil, err
+	roi := ref.Region.Resolve(r.screen.Bounds())
+	res.region = roi
+	if roi.Empty() {
+		res.err = fmt.Errorf("область поиска %s вне экрана", ref.Region)
+		r.emit(&EngineError{Op: OpTemplate, Path: ref.Path, Err: res.err.Error()})
+		return res
 	}
+	res.screen = subImage(img, roi)
 
-	return img, nil
+	res.template, res.err = loadTemplate(ref.Path, ref.Mask)
+	if res.err != nil {
+		r.emit(&EngineError{Op: OpTemplate, Path: ref.Path, Err: res.err.Error()})
+	}
+	return res
 }
 
-func templateMatch(img image.Image, template image.Image, searchScale, refineRadius int) (image.Point, float64) {
-	imgBounds := img.Bounds()
-	tmplBounds := template.Bounds()
-
-	bestLoc := image.Point{X: 0, Y: 0}
-	bestScore := -1.0
-
-	for y := imgBounds.Min.Y; y <= imgBounds.Max.Y-tmplBounds.Dy(); y += searchScale {
-		for x := imgBounds.Min.X; x <= imgBounds.Max.X-tmplBounds.Dx(); x += searchScale {
-			score := compareRegion(img, template, x, y)
-			if score > bestScore {
-				bestScore = score
-				bestLoc = image.Point{X: x, Y: y}
-			}
-		}
+func (r *runner) bestMatch(it *iteration, ref TemplateRef) (Match, bool) {
+	res := r.searchTemplate(it, ref)
+	if res.err != nil {
+		return Match{}, false
 	}
 
-	refineLoc, refineScore := refineSearch(img, template, bestLoc, refineRadius)
-	if refineScore > bestScore {
-		bestScore = refineScore
-		bestLoc = refineLoc
+	threshold := r.config.MatchThreshold
+	if res.best == nil {
+		start := time.Now()
+		m := r.matcher(it)
+		best, err := m.findBest(res.screen, res.template)
+		if err != nil {
+			// The run was stopped during the search.
+			res.err = err
+			return Match{}, false
+		}
+		res.best = &best
+
+		r.emit(&TemplateMatchResult{
+			Path:      ref.Path,
+			Region:    res.region,
+			Found:     best.Score >= threshold,
+			Match:     best,
+			Threshold: threshold,
+			Stats:     m.stats,
+			Duration:  time.Since(start),
+		})
 	}
-
-	return bestLoc, bestScore
+	return *res.best, res.best.Score >= threshold
 }
 
-func refineSearch(img image.Image, template image.Image, center image.Point, radius int) (image.Point, float64) {
-	imgBounds := img.Bounds()
-	tmplBounds := template.Bounds()
-
-	bestLoc := center
-	bestScore := -1.0
+// matcher searches with the run's settings, shares the screen pyramids of
+// the iteration and stops when the run is cancelled.
+func (r *runner) matcher(it *iteration) *matcher {
+	m := newMatcher(r.config)
+	m.ctx = r.ctx
+	m.screens = it.screens
+	return m
+}
 
-	minY := max(imgBounds.Min.Y, center.Y-radius)
-	maxY := min(imgBounds.Max.Y-tmplBounds.Dy(), center.Y+radius)
-	minX := max(imgBounds.Min.X, center.X-radius)
-	maxX := min(imgBounds.Max.X-tmplBounds.Dx(), center.X+radius)
+func (r *runner) allMatches(it *iteration, ref TemplateRef) []Match {
+	res := r.searchTemplate(it, ref)
+	if res.err != nil {
+		return nil
+	}
 
-	for y := minY; y <= maxY; y++ {
-		for x := minX; x <= maxX; x++ {
-			score := compareRegion(img, template, x, y)
-			if score > bestScore {
-				bestScore = score
-				bestLoc = image.Point{X: x, Y: y}
-			}
+	if !res.allDone {
+		config := r.config
+		start := time.Now()
+		m := r.matcher(it)
+		all, err := m.findAll(res.screen, res.template, config.MatchThreshold, config.MaxMatches, config.MatchOverlap)
+		if err != nil {
+			res.err = err
+			return nil
+		}
+		res.all = all
+		res.allDone = true
+
+		event := &TemplateMatchResult{
+			Path:      ref.Path,
+			Region:    res.region,
+			Found:     len(res.all) > 0,
+			All:       true,
+			Matches:   res.all,
+			Threshold: config.MatchThreshold,
+			Stats:     m.stats,
+			Duration:  time.Since(start),
 		}
+		if len(res.all) > 0 {
+			event.Match = res.all[0]
+		}
+		r.emit(event)
 	}
-
-	return bestLoc, bestScore
+	return res.all
 }
 
-func compareRegion(img image.Image, template image.Image, startX, startY int) float64 {
-	tmplBounds := template.Bounds()
-	width := tmplBounds.Dx()
-	height := tmplBounds.Dy()
-
-	var totalDiff float64
-	var maxDiff float64 = float64(width * height * 255 * 3)
-
-	for ty := 0; ty < height; ty++ {
-		for tx := 0; tx < width; tx++ {
-			imgColor := img.At(startX+tx, startY+ty)
-			tmplColor := template.At(tmplBounds.Min.X+tx, tmplBounds.Min.Y+ty)
-
-			ir, ig, ib, _ := imgColor.RGBA()
-			tr, tg, tb, _ := tmplColor.RGBA()
-
-			ir8 := uint8(ir >> 8)
-			ig8 := uint8(ig >> 8)
-			ib8 := uint8(ib >> 8)
-
-			tr8 := uint8(tr >> 8)
-			tg8 := uint8(tg >> 8)
-			tb8 := uint8(tb >> 8)
+func loadImage(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
 
-			totalDiff += float64(abs(int(ir8) - int(tr8)))
-			totalDiff += float64(abs(int(ig8) - int(tg8)))
-			totalDiff += float64(abs(int(ib8) - int(tb8)))
-		}
+	img, err := png.Decode(file)
+	if err != nil {
+		return nil, err
 	}
 
-	similarity := 1.0 - (totalDiff / maxDiff)
-	return similarity
+	return img, nil
 }
 
 func hexToRGB(hex uint32) (uint8, uint8, uint8) {
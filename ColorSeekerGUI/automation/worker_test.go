@@ -0,0 +1,284 @@
+package automation
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loopScreen is a noise frame with Good.png and bad.png pasted at good and
+// bad, and the color probe's column filled with the target color or not.
+func loopScreen(t *testing.T, config Config, colorFound bool, good, bad image.Point) (*image.RGBA, Match, Match) {
+	t.Helper()
+	frame := image.NewRGBA(image.Rect(0, 0, 400, 300))
+	rand.New(rand.NewSource(int64(good.X))).Read(frame.Pix)
+	for i := 3; i < len(frame.Pix); i += 4 {
+		frame.Pix[i] = 255
+	}
+
+	fill := color.RGBA{A: 255}
+	if colorFound {
+		r, g, b := hexToRGB(config.TargetColor)
+		fill = color.RGBA{R: r, G: g, B: b, A: 255}
+	}
+	draw.Draw(frame, config.colorRect(), image.NewUniform(fill), image.Point{}, draw.Src)
+
+	paste := func(path string, at image.Point) Match {
+		img, err := loadImage(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rect := img.Bounds().Sub(img.Bounds().Min).Add(at)
+		draw.Draw(frame, rect, img, img.Bounds().Min, draw.Src)
+		return Match{Location: at, Size: rect.Size()}
+	}
+	return frame, paste("../Good.png", good), paste("../bad.png", bad)
+}
+
+func loopConfig() Config {
+	config := DefaultConfig()
+	config.ColorX1, config.ColorY1, config.ColorX2, config.ColorY2 = 5, 5, 5, 25
+	config.LoopDelay = 0
+	config.Rules = legacyConfig{GoodImagePath: "../Good.png", BadImagePath: "../bad.png"}.rules()
+	return config
+}
+
+func wantClick(t *testing.T, got RecordedAction, target Match) {
+	t.Helper()
+	center := target.Center()
+	if got.Op != "click" || got.Button != "left" || got.X != center.X || got.Y != center.Y {
+		t.Errorf("recorded %+v, want a left click at %v", got, center)
+	}
+}
+
+func TestRunOnceClicksMatchedTemplate(t *testing.T) {
+	config := loopConfig()
+	withColor, good, _ := loopScreen(t, config, true, image.Pt(123, 45), image.Pt(250, 160))
+	withoutColor, _, bad := loopScreen(t, config, false, image.Pt(31, 201), image.Pt(301, 77))
+
+	screen := NewMemoryScreen(withColor)
+	input := NewRecordingActuator()
+	RunOnce(context.Background(), config, nil, WithScreen(screen), WithActuator(input))
+
+	screen.SetFrame(withoutColor)
+	RunOnce(context.Background(), config, nil, WithScreen(screen), WithActuator(input))
+
+	clicks := input.Clicks()
+	if len(clicks) != 2 {
+		t.Fatalf("recorded %d clicks, want 2: %+v", len(clicks), input.Actions())
+	}
+	wantClick(t, clicks[0], good)
+	wantClick(t, clicks[1], bad)
+}
+
+func TestRunOnceReportsMissingScreen(t *testing.T) {
+	// A screen without bounds, like a display that is not connected, has to
+	// fail the capture instead of passing for an empty frame.
+	bus := NewBus()
+	events := bus.Subscribe(64, DropOldest)
+	RunOnce(context.Background(), loopConfig(), bus, WithScreen(NewMemoryScreen(nil)), WithActuator(NewRecordingActuator()))
+	bus.Close()
+
+	var errs []*EngineError
+	for e := range events.Events() {
+		if err, ok := e.(*EngineError); ok {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 || errs[0].Op != OpCapture {
+		t.Fatalf("errors %+v, want a %q error first", errs, OpCapture)
+	}
+	for _, err := range errs {
+		if err.Op == OpTemplate {
+			t.Errorf("template error %q, want only the capture to fail", err.Err)
+		}
+	}
+}
+
+// absentRule presses Enter when ref is not on screen.
+func absentRule(ref TemplateRef) Config {
+	config := loopConfig()
+	config.Rules = []Rule{{
+		When:    Condition{Type: CondTemplateAbsent, Template: &ref},
+		Actions: []Action{{Type: ActionKey, Key: "enter"}},
+	}}
+	return config
+}
+
+func TestRunOnceAbsentNeedsASearch(t *testing.T) {
+	// A template that cannot be searched for is not absent: neither a
+	// missing file, nor a screen without a frame, nor a cancelled search
+	// may let the rule run.
+	frame := image.NewRGBA(image.Rect(0, 0, 400, 300))
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, tc := range []struct {
+		name   string
+		ctx    context.Context
+		screen ScreenSource
+		ref    TemplateRef
+	}{
+		{"missing file", context.Background(), NewMemoryScreen(frame), TemplateRef{Path: "missing.png"}},
+		{"no frame", context.Background(), NewMemoryScreen(nil), TemplateRef{Path: "../Good.png"}},
+		{"cancelled", cancelled, NewMemoryScreen(frame), TemplateRef{Path: "../Good.png"}},
+		{"not, missing file", context.Background(), NewMemoryScreen(frame), TemplateRef{Path: "missing.png"}},
+	} {
+		config := absentRule(tc.ref)
+		if strings.HasPrefix(tc.name, "not") {
+			config.Rules[0].When = Condition{Type: CondNot, Conditions: []Condition{{Type: CondTemplate, Template: &tc.ref}}}
+		}
+		input := NewRecordingActuator()
+		RunOnce(tc.ctx, config, nil, WithScreen(tc.screen), WithActuator(input))
+		if actions := input.Actions(); len(actions) != 0 {
+			t.Errorf("%s: performed %+v, want nothing", tc.name, actions)
+		}
+	}
+
+	// The same rule does run when the search finds nothing.
+	input := NewRecordingActuator()
+	RunOnce(context.Background(), absentRule(TemplateRef{Path: "../Good.png"}), nil, WithScreen(NewMemoryScreen(frame)), WithActuator(input))
+	if actions := input.Actions(); len(actions) != 1 || actions[0].Op != "press" {
+		t.Errorf("performed %+v, want Enter pressed", actions)
+	}
+}
+
+func TestRunReplaysFileScreen(t *testing.T) {
+	config := loopConfig()
+	withColor, good, _ := loopScreen(t, config, true, image.Pt(77, 133), image.Pt(205, 20))
+	withoutColor, _, bad := loopScreen(t, config, false, image.Pt(340, 250), image.Pt(19, 103))
+
+	dir := t.TempDir()
+	var paths []string
+	for i, frame := range []image.Image{withColor, withoutColor} {
+		path := filepath.Join(dir, string(rune('a'+i))+".png")
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := png.Encode(file, frame); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+		paths = append(paths, path)
+	}
+	screen, err := NewFileScreen(paths...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bus := NewBus()
+	events := bus.Subscribe(1024, DropOldest)
+	input := NewRecordingActuator()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		Run(ctx, config, bus, WithScreen(screen), WithActuator(input))
+		close(done)
+	}()
+
+	// The frames alternate, so the rules do too: Good.png on the first,
+	// bad.png on the second, Good.png again on the third.
+	performed := 0
+	timeout := time.After(30 * time.Second)
+	for performed < 3 {
+		select {
+		case e := <-events.Events():
+			if _, ok := e.(*ActionPerformed); ok {
+				performed++
+			}
+		case <-timeout:
+			t.Fatalf("only %d actions performed: %+v", performed, input.Actions())
+		}
+	}
+	cancel()
+	<-done
+
+	clicks := input.Clicks()
+	wantClick(t, clicks[0], good)
+	wantClick(t, clicks[1], bad)
+	wantClick(t, clicks[2], good)
+}
+
+func TestLoadConfigMigratesLegacyFile(t *testing.T) {
+	for _, tc := range []struct {
+		name, data string
+		good, bad  TemplateRef
+		click      Action
+	}{
+		{
+			name: "baseline",
+			data: `{"color_x1": 10, "color_y1": 20, "color_x2": 10, "color_y2": 60,
+				"target_color": 16711680, "shade_variation": 12,
+				"good_image_path": "img/ok.png", "bad_image_path": "img/fail.png",
+				"loop_delay_seconds": 3, "match_threshold": 0.9,
+				"search_scale": 2, "refine_radius": 4}`,
+			good: TemplateRef{Path: "img/ok.png"},
+			bad:  TemplateRef{Path: "img/fail.png"},
+		},
+		{
+			name: "masks and regions",
+			data: `{"good_image_path": "ok.png", "good_mask_path": "ok_mask.png",
+				"good_region": {"x": 0.1, "y": 0.2, "width": 0.5, "height": 0.25, "relative": true},
+				"bad_mask_path": "bad_mask.png",
+				"bad_region": {"x": 100, "y": 50, "width": 300, "height": 200},
+				"click_target": "nearest", "click_near_x": 640, "click_near_y": 480}`,
+			good: TemplateRef{Path: "ok.png", Mask: "ok_mask.png",
+				Region: &SearchRegion{X: 0.1, Y: 0.2, Width: 0.5, Height: 0.25, Relative: true}},
+			bad: TemplateRef{Path: "bad.png", Mask: "bad_mask.png",
+				Region: &SearchRegion{X: 100, Y: 50, Width: 300, Height: 200}},
+			click: Action{Select: ClickNearest, NearX: 640, NearY: 480},
+		},
+	} {
+		path := filepath.Join(t.TempDir(), "config.json")
+		if err := os.WriteFile(path, []byte(tc.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		config, err := LoadConfig(path)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if len(config.Rules) != 2 {
+			t.Fatalf("%s: %d rules, want 2", tc.name, len(config.Rules))
+		}
+
+		color := Condition{Type: CondColor}
+		for i, want := range []struct {
+			when Condition
+			ref  TemplateRef
+		}{
+			{color, tc.good},
+			{Condition{Type: CondNot, Conditions: []Condition{color}}, tc.bad},
+		} {
+			rule := config.Rules[i]
+			if rule.When.String() != want.when.String() || len(rule.Actions) != 1 {
+				t.Errorf("%s: rule %d is %v, want a click when %v", tc.name, i, rule, want.when)
+				continue
+			}
+			action := rule.Actions[0]
+			if action.Type != ActionClick || action.Template == nil || !sameRef(*action.Template, want.ref) {
+				t.Errorf("%s: rule %d acts %+v on %+v, want a click on %+v", tc.name, i, action, action.Template, want.ref)
+			}
+			if action.Select != tc.click.Select || action.NearX != tc.click.NearX || action.NearY != tc.click.NearY {
+				t.Errorf("%s: rule %d selects %q near %d,%d, want %q near %d,%d", tc.name, i,
+					action.Select, action.NearX, action.NearY, tc.click.Select, tc.click.NearX, tc.click.NearY)
+			}
+		}
+	}
+}
+
+func sameRef(a, b TemplateRef) bool {
+	if a.Path != b.Path || a.Mask != b.Mask || (a.Region == nil) != (b.Region == nil) {
+		return false
+	}
+	return a.Region == nil || *a.Region == *b.Region
+}
@@ -0,0 +1,406 @@
+// Package cli runs the automation without a window, for build agents and SSH
+// sessions.
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	_ "image/png"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"code-rewrite-runner/api"
+	"code-rewrite-runner/automation"
+	"code-rewrite-runner/render"
+)
+
+const usage = `Использование: ColorSeekerGUI <команда> [флаги]
+
+Команды:
+  run       запустить цикл автоматизации (до Ctrl+C)
+  once      выполнить одну итерацию
+  validate  проверить конфигурацию
+  match     найти шаблон на снимке экрана и вывести результат в JSON
+  displays  показать мониторы и их координаты
+
+Без команды запускается графический интерфейс.
+Флаги команды: ColorSeekerGUI <команда> -h
+`
+
+// Exit codes: failure covers invalid configs, templates that were not found
+// and iterations of once that reported an error, usage covers bad command
+// lines.
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+// Run executes the subcommand in args (without the program name) and returns
+// the process exit code.
+func Run(args []string) int {
+	return run(args, os.Stdout, os.Stderr)
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "run":
+		return runLoop(args[1:], stdout, stderr, false)
+	case "once":
+		return runLoop(args[1:], stdout, stderr, true)
+	case "validate":
+		return validate(args[1:], stdout, stderr)
+	case "match":
+		return match(args[1:], stdout, stderr)
+	case "displays":
+		return displays(args[1:], stdout, stderr)
+	case "help", "-h", "--help":
+		fmt.Fprint(stdout, usage)
+		return exitOK
+	default:
+		fmt.Fprintf(stderr, "Неизвестная команда: %s\n\n%s", args[0], usage)
+		return exitUsage
+	}
+}
+
+func newFlagSet(name string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	return fs
+}
+
+func runLoop(args []string, stdout, stderr io.Writer, once bool) int {
+	name := "run"
+	if once {
+		name = "once"
+	}
+	fs := newFlagSet(name, stderr)
+	configFile := fs.String("config", "config.json", "файл конфигурации")
+	jsonOut := fs.Bool("json", false, "выводить события в JSON, по одному на строку")
+	screenFile := fs.String("screen", "", "искать на снимке экрана (PNG) вместо живого экрана; несколько снимков через запятую сменяют друг друга по итерациям")
+	dryRun := fs.Bool("dry-run", false, "не трогать мышь и клавиатуру, только писать действия в лог")
+	apiAddr := ""
+	if !once {
+		fs.StringVar(&apiAddr, "api", "", "включить HTTP API на адресе, например 127.0.0.1:8765 (по умолчанию api_listen из конфигурации)")
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	var opts []automation.Option
+	var apiOpts []api.Option
+	if *screenFile != "" {
+		screen, err := automation.NewFileScreen(strings.Split(*screenFile, ",")...)
+		if err != nil {
+			fmt.Fprintf(stderr, "Ошибка загрузки снимка: %v\n", err)
+			return exitFailure
+		}
+		opts = append(opts, automation.WithScreen(screen))
+		apiOpts = append(apiOpts, api.WithScreen(screen))
+	}
+	if *dryRun {
+		opts = append(opts, automation.WithActuator(automation.NewRecordingActuator()))
+	}
+
+	config, err := automation.LoadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "Ошибка загрузки конфигурации %s: %v\n", *configFile, err)
+		return exitFailure
+	}
+	if apiAddr != "" {
+		config.APIListen = apiAddr
+	}
+	if err := config.Validate(); err != nil {
+		printProblems(stderr, *configFile, err)
+		return exitFailure
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	bus := automation.NewBus()
+	events := bus.Subscribe(1024, automation.DropOldest)
+	printed := make(chan struct{})
+	go func() {
+		printEvents(stdout, events.Events(), *jsonOut)
+		close(printed)
+	}()
+
+	switch {
+	case once:
+		if err = automation.RunOnce(ctx, config, bus, opts...); err != nil {
+			err = fmt.Errorf("итерация завершилась с ошибкой: %w", err)
+		}
+	case config.APIListen != "":
+		err = serve(ctx, automation.NewEngine(config, bus, opts...), config.APIListen, apiOpts, stderr)
+	default:
+		engine := automation.NewEngine(config, bus, opts...)
+		if err = engine.Start(ctx); err == nil {
+			err = <-engine.Done()
+		}
+	}
+	bus.Close()
+	<-printed
+	if n := events.Dropped(); n > 0 {
+		fmt.Fprintf(stderr, "Пропущено событий: %d (вывод не успевал)\n", n)
+	}
+
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+// serve runs the engine under the HTTP API until ctx is cancelled. The loop
+// is started right away, but stopping it through the API does not end the
+// process, so that it can be reconfigured and started again.
+func serve(ctx context.Context, engine *automation.Engine, addr string, opts []api.Option, stderr io.Writer) error {
+	server := api.New(engine, opts...)
+	defer server.Close()
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ListenAndServe(ctx, addr)
+	}()
+	fmt.Fprintf(stderr, "HTTP API: http://%s/api/state\n", addr)
+
+	if err := engine.Start(ctx); err != nil {
+		return err
+	}
+
+	var err error
+	select {
+	case err = <-served:
+		if err != nil {
+			err = fmt.Errorf("ошибка HTTP API: %w", err)
+		}
+	case <-ctx.Done():
+		err = <-served
+	}
+
+	engine.Stop()
+	if done := engine.Done(); done != nil {
+		if runErr, ok := <-done; ok && err == nil {
+			err = runErr
+		}
+	}
+	if err == nil {
+		err = engine.Err()
+	}
+	return err
+}
+
+func printEvents(w io.Writer, events <-chan automation.Event, jsonOut bool) {
+	for event := range events {
+		if !jsonOut {
+			fmt.Fprintln(w, render.Line(event))
+			continue
+		}
+		if data, err := render.JSON(event); err == nil {
+			fmt.Fprintf(w, "%s\n", data)
+		}
+	}
+}
+
+func validate(args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("validate", stderr)
+	configFile := fs.String("config", "config.json", "файл конфигурации")
+	jsonOut := fs.Bool("json", false, "вывести результат в JSON")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	config, err := automation.LoadConfig(*configFile)
+	if err == nil {
+		err = config.Validate()
+	}
+
+	if *jsonOut {
+		result := struct {
+			Valid  bool     `json:"valid"`
+			Errors []string `json:"errors"`
+		}{Valid: err == nil, Errors: automation.Problems(err)}
+		writeJSON(stdout, result)
+	} else if err != nil {
+		printProblems(stdout, *configFile, err)
+	} else {
+		fmt.Fprintf(stdout, "Конфигурация %s в порядке: правил %d\n", *configFile, len(config.Rules))
+	}
+
+	if err != nil {
+		return exitFailure
+	}
+	return exitOK
+}
+
+func printProblems(w io.Writer, configFile string, err error) {
+	fmt.Fprintf(w, "Ошибки в конфигурации %s:\n", configFile)
+	for _, problem := range automation.Problems(err) {
+		fmt.Fprintf(w, "  - %s\n", problem)
+	}
+}
+
+// matchResult is the JSON printed by the match command. Location and size are
+// in screen pixels, the center is where a click would land.
+type matchResult struct {
+	Found     bool    `json:"found"`
+	X         int     `json:"x"`
+	Y         int     `json:"y"`
+	Width     int     `json:"width"`
+	Height    int     `json:"height"`
+	CenterX   int     `json:"center_x"`
+	CenterY   int     `json:"center_y"`
+	Scale     float64 `json:"scale"`
+	Score     float64 `json:"score"`
+	Threshold float64 `json:"threshold"`
+}
+
+func match(args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("match", stderr)
+	screenFile := fs.String("screen", "", "снимок экрана (PNG)")
+	templateFile := fs.String("template", "", "шаблон (PNG)")
+	configFile := fs.String("config", "", "файл конфигурации с настройками поиска (необязательно)")
+	all := fs.Bool("all", false, "вывести все совпадения")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *screenFile == "" || *templateFile == "" {
+		fmt.Fprintln(stderr, "Нужно указать --screen и --template")
+		return exitUsage
+	}
+
+	config := automation.DefaultConfig()
+	if *configFile != "" {
+		var err error
+		if config, err = automation.LoadConfig(*configFile); err != nil {
+			fmt.Fprintf(stderr, "Ошибка загрузки конфигурации %s: %v\n", *configFile, err)
+			return exitFailure
+		}
+	}
+
+	screen, err := loadPNG(*screenFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "Ошибка загрузки снимка: %v\n", err)
+		return exitFailure
+	}
+	template, err := loadPNG(*templateFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "Ошибка загрузки шаблона: %v\n", err)
+		return exitFailure
+	}
+
+	if *all {
+		matches, err := automation.FindAll(screen, template, config)
+		if err != nil {
+			fmt.Fprintf(stderr, "Ошибка поиска: %v\n", err)
+			return exitFailure
+		}
+		results := make([]matchResult, 0, len(matches))
+		for _, m := range matches {
+			results = append(results, newMatchResult(m, config.MatchThreshold))
+		}
+		writeJSON(stdout, results)
+		if len(results) == 0 {
+			return exitFailure
+		}
+		return exitOK
+	}
+
+	best, err := automation.FindBest(screen, template, config)
+	if err != nil {
+		fmt.Fprintf(stderr, "Ошибка поиска: %v\n", err)
+		return exitFailure
+	}
+	result := newMatchResult(best, config.MatchThreshold)
+	writeJSON(stdout, result)
+	if !result.Found {
+		return exitFailure
+	}
+	return exitOK
+}
+
+// displayResult is one entry printed by the displays command.
+type displayResult struct {
+	Index  int `json:"index"`
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+func displays(args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("displays", stderr)
+	jsonOut := fs.Bool("json", false, "вывести результат в JSON")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	results := []displayResult{}
+	for i, bounds := range automation.Displays() {
+		results = append(results, displayResult{
+			Index:  i,
+			X:      bounds.Min.X,
+			Y:      bounds.Min.Y,
+			Width:  bounds.Dx(),
+			Height: bounds.Dy(),
+		})
+	}
+
+	if *jsonOut {
+		writeJSON(stdout, results)
+	} else {
+		for _, d := range results {
+			fmt.Fprintf(stdout, "%d: X=%d, Y=%d, %d×%d\n", d.Index, d.X, d.Y, d.Width, d.Height)
+		}
+	}
+	if len(results) == 0 {
+		fmt.Fprintln(stderr, "Мониторы не найдены")
+		return exitFailure
+	}
+	return exitOK
+}
+
+func newMatchResult(m automation.Match, threshold float64) matchResult {
+	center := m.Center()
+	return matchResult{
+		Found:     m.Score >= threshold,
+		X:         m.Location.X,
+		Y:         m.Location.Y,
+		Width:     m.Size.X,
+		Height:    m.Size.Y,
+		CenterX:   center.X,
+		CenterY:   center.Y,
+		Scale:     m.Scale,
+		Score:     m.Score,
+		Threshold: threshold,
+	}
+}
+
+func loadPNG(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	return img, err
+}
+
+func writeJSON(w io.Writer, v any) {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
@@ -0,0 +1,185 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/draw"
+	"image/png"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"code-rewrite-runner/automation"
+)
+
+// command runs the CLI with args and returns the exit code and the output.
+func command(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+// goodTemplate is the absolute path of Good.png, so that configs written to
+// a temporary directory can refer to it.
+func goodTemplate(t *testing.T) string {
+	t.Helper()
+	path, err := filepath.Abs("../Good.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writeScreen saves a frame of noise, with Good.png pasted at at unless at
+// is nil, and returns its path.
+func writeScreen(t *testing.T, at *image.Point) string {
+	t.Helper()
+	frame := image.NewRGBA(image.Rect(0, 0, 320, 240))
+	rand.New(rand.NewSource(1)).Read(frame.Pix)
+	for i := 3; i < len(frame.Pix); i += 4 {
+		frame.Pix[i] = 0xff
+	}
+	if at != nil {
+		file, err := os.Open(goodTemplate(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		img, err := png.Decode(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		draw.Draw(frame, img.Bounds().Sub(img.Bounds().Min).Add(*at), img, img.Bounds().Min, draw.Src)
+	}
+
+	path := filepath.Join(t.TempDir(), "screen.png")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, frame); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writeConfig saves a config whose only rule always clicks template.
+func writeConfig(t *testing.T, template string, edit func(*automation.Config)) string {
+	t.Helper()
+	config := automation.DefaultConfig()
+	config.ColorX1, config.ColorY1, config.ColorX2, config.ColorY2 = 0, 0, 0, 20
+	ref := automation.TemplateRef{Path: template}
+	config.Rules = []automation.Rule{{
+		When:    automation.Condition{Type: automation.CondAlways},
+		Actions: []automation.Action{{Type: automation.ActionClick, Template: &ref}},
+	}}
+	if edit != nil {
+		edit(&config)
+	}
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := config.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestUsage(t *testing.T) {
+	for _, args := range [][]string{
+		nil,
+		{"frobnicate"},
+		{"match"},
+		{"validate", "--no-such-flag"},
+	} {
+		if code, _, _ := command(args...); code != exitUsage {
+			t.Errorf("%q: exit code %d, want %d", args, code, exitUsage)
+		}
+	}
+	if code, stdout, _ := command("help"); code != exitOK || !strings.Contains(stdout, "validate") {
+		t.Errorf("help: exit code %d, output %q", code, stdout)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := writeConfig(t, goodTemplate(t), nil)
+	if code, stdout, _ := command("validate", "--config", valid); code != exitOK {
+		t.Errorf("valid config: exit code %d, output %q", code, stdout)
+	}
+
+	invalid := writeConfig(t, "missing.png", func(c *automation.Config) {
+		c.MatchThreshold = 2
+		c.Rules = append(c.Rules, automation.Rule{When: automation.Condition{Type: "sometimes"}})
+	})
+	code, stdout, _ := command("validate", "--config", invalid, "--json")
+	if code != exitFailure {
+		t.Errorf("invalid config: exit code %d, want %d", code, exitFailure)
+	}
+	var result struct {
+		Valid  bool     `json:"valid"`
+		Errors []string `json:"errors"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+		t.Fatalf("validate --json printed %q: %v", stdout, err)
+	}
+	// The threshold, the missing file, the unknown condition, no actions.
+	if result.Valid || len(result.Errors) != 4 {
+		t.Errorf("validate --json = %+v, want 4 errors", result)
+	}
+
+	if code, _, _ := command("validate", "--config", filepath.Join(t.TempDir(), "none.json")); code != exitFailure {
+		t.Errorf("missing config: exit code %d, want %d", code, exitFailure)
+	}
+}
+
+func TestMatchJSON(t *testing.T) {
+	at := image.Pt(37, 21)
+	code, stdout, stderr := command("match", "--screen", writeScreen(t, &at), "--template", goodTemplate(t))
+	if code != exitOK {
+		t.Fatalf("exit code %d, stderr %q", code, stderr)
+	}
+	var result matchResult
+	if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+		t.Fatalf("match printed %q: %v", stdout, err)
+	}
+	if !result.Found || result.X != at.X || result.Y != at.Y || result.Score != 1 ||
+		result.CenterX != at.X+result.Width/2 || result.CenterY != at.Y+result.Height/2 {
+		t.Errorf("match = %+v, want found at %v", result, at)
+	}
+
+	code, stdout, _ = command("match", "--all", "--screen", writeScreen(t, nil), "--template", goodTemplate(t))
+	var all []matchResult
+	if err := json.Unmarshal([]byte(stdout), &all); err != nil {
+		t.Fatalf("match --all printed %q: %v", stdout, err)
+	}
+	if code != exitFailure || len(all) != 0 {
+		t.Errorf("match --all without the template: exit code %d, %d matches", code, len(all))
+	}
+}
+
+func TestOnceExitCode(t *testing.T) {
+	at := image.Pt(100, 60)
+	screen := writeScreen(t, &at)
+
+	code, stdout, stderr := command("once", "--dry-run", "--json", "--screen", screen, "--config", writeConfig(t, goodTemplate(t), nil))
+	if code != exitOK || !strings.Contains(stdout, `"action_performed"`) {
+		t.Errorf("clicking a template on screen: exit code %d, stdout %q, stderr %q", code, stdout, stderr)
+	}
+
+	// The template is not on screen: the click fails.
+	code, _, stderr = command("once", "--dry-run", "--screen", writeScreen(t, nil), "--config", writeConfig(t, goodTemplate(t), nil))
+	if code != exitFailure {
+		t.Errorf("clicking a template not on screen: exit code %d, stderr %q", code, stderr)
+	}
+
+	// A verify check that never passes.
+	verified := writeConfig(t, goodTemplate(t), func(c *automation.Config) {
+		c.Rules[0].Actions[0].Verify = &automation.Verify{Retries: 1, BackoffMs: 1}
+	})
+	if code, _, stderr = command("once", "--dry-run", "--screen", screen, "--config", verified); code != exitFailure {
+		t.Errorf("verify giving up: exit code %d, stderr %q", code, stderr)
+	}
+}
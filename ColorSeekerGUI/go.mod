@@ -6,6 +6,7 @@ require (
 	gioui.org v0.4.1
 	github.com/go-vgo/robotgo v0.110.8
 	github.com/kbinani/screenshot v0.0.0-20210720154843-7d3a670d8329
+	github.com/vcaesar/keycode v0.10.1
 )
 
 require (
@@ -31,7 +32,6 @@ require (
 	github.com/tklauser/numcpus v0.10.0 // indirect
 	github.com/vcaesar/gops v0.41.0 // indirect
 	github.com/vcaesar/imgo v0.41.0 // indirect
-	github.com/vcaesar/keycode v0.10.1 // indirect
 	github.com/vcaesar/screenshot v0.11.1 // indirect
 	github.com/vcaesar/tt v0.20.1 // indirect
 	github.com/yusufpapurcu/wmi v1.2.4 // indirect
@@ -3,6 +3,7 @@ package gui
 import (
         "context"
         "fmt"
+        "image"
         "image/color"
         "log"
         "strconv"
@@ -20,7 +21,9 @@ import (
         "gioui.org/widget"
         "gioui.org/widget/material"
 
+        "code-rewrite-runner/api"
         "code-rewrite-runner/automation"
+        "code-rewrite-runner/render"
 )
 
 const configFile = "config.json"
@@ -29,7 +32,7 @@ type App struct {
         window     *app.Window
         theme      *material.Theme
         config     automation.Config
-        statusChan chan automation.Status
+        events     *automation.Subscription
         
         logMutex   sync.Mutex
         logBuffer  []string
@@ -37,6 +40,7 @@ type App struct {
 
         startBtn widget.Clickable
         stopBtn  widget.Clickable
+        pauseBtn widget.Clickable
 
         colorX1Editor        widget.Editor
         colorX2Editor        widget.Editor
@@ -45,10 +49,17 @@ type App struct {
         targetColorEditor    widget.Editor
         loopDelayEditor      widget.Editor
         matchThresholdEditor widget.Editor
+        displayEditor        widget.Editor
 
-        running   bool
-        cancelFn  context.CancelFunc
-        statusCtx context.Context
+        // displays are the bounds of the monitors found at start.
+        displays []image.Rectangle
+
+        engine *automation.Engine
+
+        // pendingConfig is a configuration received through the HTTP API,
+        // applied to the fields on the next frame.
+        pendingMutex  sync.Mutex
+        pendingConfig *automation.Config
 
         list widget.List
 }
@@ -67,11 +78,12 @@ func NewApp() *App {
                 window:     app.NewWindow(app.Title("Code Rewrite Runner"), app.Size(unit.Dp(900), unit.Dp(700))),
                 theme:      th,
                 config:     config,
-                statusChan: make(chan automation.Status, 100),
                 logBuffer:  make([]string, 0, 200),
                 maxLogs:    200,
-                running:    false,
         }
+        bus := automation.NewBus()
+        a.events = bus.Subscribe(256, automation.DropOldest)
+        a.engine = automation.NewEngine(config, bus)
 
         a.colorX1Editor.SingleLine = true
         a.colorX2Editor.SingleLine = true
@@ -80,7 +92,21 @@ func NewApp() *App {
         a.targetColorEditor.SingleLine = true
         a.loopDelayEditor.SingleLine = true
         a.matchThresholdEditor.SingleLine = true
+        a.displayEditor.SingleLine = true
+
+        a.displays = automation.Displays()
+        for i, display := range a.displays {
+                log.Printf("Монитор %d: %s", i, displayBounds(display))
+        }
+
+        a.setEditors(config)
+
+        a.list.Axis = layout.Vertical
+
+        return a
+}
 
+func (a *App) setEditors(config automation.Config) {
         a.colorX1Editor.SetText(fmt.Sprintf("%d", config.ColorX1))
         a.colorX2Editor.SetText(fmt.Sprintf("%d", config.ColorX2))
         a.colorY1Editor.SetText(fmt.Sprintf("%d", config.ColorY1))
@@ -88,42 +114,56 @@ func NewApp() *App {
         a.targetColorEditor.SetText(fmt.Sprintf("%06X", config.TargetColor))
         a.loopDelayEditor.SetText(fmt.Sprintf("%d", config.LoopDelay))
         a.matchThresholdEditor.SetText(fmt.Sprintf("%.0f", config.MatchThreshold*100))
+        if config.Display == automation.DisplayAll {
+                a.displayEditor.SetText("все")
+        } else {
+                a.displayEditor.SetText(fmt.Sprintf("%d", config.Display))
+        }
+}
 
-        a.list.Axis = layout.Vertical
-
-        return a
+func displayBounds(r image.Rectangle) string {
+        return fmt.Sprintf("X=%d, Y=%d, %d×%d", r.Min.X, r.Min.Y, r.Dx(), r.Dy())
 }
 
 func (a *App) Run() error {
         var ops op.Ops
         
         go func() {
-                for status := range a.statusChan {
-                        logLine := fmt.Sprintf("[%s] %s",
-                                status.Timestamp.Format("15:04:05"),
-                                status.Message)
-                        
-                        a.logMutex.Lock()
-                        a.logBuffer = append(a.logBuffer, logLine)
-                        if len(a.logBuffer) > a.maxLogs {
-                                a.logBuffer = a.logBuffer[1:]
+                var dropped uint64
+                for event := range a.events.Events() {
+                        // Old lines are dropped when the window cannot keep up;
+                        // say so instead of silently leaving a gap.
+                        if n := a.events.Dropped(); n != dropped {
+                                a.logf("⚠ Пропущено событий лога: %d", n-dropped)
+                                dropped = n
                         }
-                        a.logMutex.Unlock()
-                        
-                        a.window.Invalidate()
+                        a.appendLog(render.Line(event))
                 }
         }()
 
+        if addr := a.config.APIListen; addr != "" {
+                ctx, cancel := context.WithCancel(context.Background())
+                defer cancel()
+                server := api.New(a.engine, api.WithConfigHook(a.receiveConfig))
+                defer server.Close()
+
+                go func() {
+                        if err := server.ListenAndServe(ctx, addr); err != nil {
+                                a.logf("✗ HTTP API: %v", err)
+                        }
+                }()
+                a.logf("HTTP API: http://%s/api/state", addr)
+        }
+
         for {
                 e := a.window.NextEvent()
                 switch e := e.(type) {
                 case app.DestroyEvent:
-                        if a.cancelFn != nil {
-                                a.cancelFn()
-                        }
+                        a.engine.Stop()
                         return e.Err
 
                 case app.FrameEvent:
+                        a.applyPendingConfig()
                         gtx := app.NewContext(&ops, e)
                         a.layout(gtx)
                         e.Frame(gtx.Ops)
@@ -131,6 +171,22 @@ func (a *App) Run() error {
         }
 }
 
+func (a *App) appendLog(line string) {
+        a.logMutex.Lock()
+        a.logBuffer = append(a.logBuffer, line)
+        if len(a.logBuffer) > a.maxLogs {
+                a.logBuffer = a.logBuffer[1:]
+        }
+        a.logMutex.Unlock()
+
+        a.window.Invalidate()
+}
+
+// logf writes a line of the GUI's own to the log, next to the engine events.
+func (a *App) logf(format string, args ...interface{}) {
+        a.appendLog(fmt.Sprintf("[%s] %s", time.Now().Format("15:04:05"), fmt.Sprintf(format, args...)))
+}
+
 func (a *App) layout(gtx layout.Context) layout.Dimensions {
         return layout.Flex{
                 Axis: layout.Vertical,
@@ -172,6 +228,9 @@ func (a *App) header(gtx layout.Context) layout.Dimensions {
 }
 
 func (a *App) controls(gtx layout.Context) layout.Dimensions {
+        state := a.engine.State()
+        gray := color.NRGBA{R: 100, G: 100, B: 100, A: 255}
+
         return layout.UniformInset(unit.Dp(16)).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
                 return layout.Flex{
                         Axis:    layout.Horizontal,
@@ -179,39 +238,51 @@ func (a *App) controls(gtx layout.Context) layout.Dimensions {
                         Alignment: layout.Middle,
                 }.Layout(gtx,
                         layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-                                if a.startBtn.Clicked(gtx) && !a.running {
+                                if a.startBtn.Clicked(gtx) && !state.Active() {
                                         a.applySettings()
                                         a.startAutomation()
                                 }
                                 btn := material.Button(a.theme, &a.startBtn, "START")
                                 btn.Background = color.NRGBA{R: 0, G: 150, B: 0, A: 255}
-                                if a.running {
-                                        btn.Background = color.NRGBA{R: 100, G: 100, B: 100, A: 255}
+                                if state.Active() {
+                                        btn.Background = gray
                                         gtx = gtx.Disabled()
                                 }
                                 return btn.Layout(gtx)
                         }),
                         layout.Rigid(layout.Spacer{Width: unit.Dp(16)}.Layout),
                         layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-                                if a.stopBtn.Clicked(gtx) && a.running {
+                                if a.pauseBtn.Clicked(gtx) {
+                                        a.togglePause()
+                                }
+                                label := "PAUSE"
+                                if state == automation.StatePaused {
+                                        label = "RESUME"
+                                }
+                                btn := material.Button(a.theme, &a.pauseBtn, label)
+                                btn.Background = color.NRGBA{R: 200, G: 130, B: 0, A: 255}
+                                if state != automation.StateRunning && state != automation.StatePaused {
+                                        btn.Background = gray
+                                        gtx = gtx.Disabled()
+                                }
+                                return btn.Layout(gtx)
+                        }),
+                        layout.Rigid(layout.Spacer{Width: unit.Dp(16)}.Layout),
+                        layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+                                if a.stopBtn.Clicked(gtx) && (state == automation.StateRunning || state == automation.StatePaused) {
                                         a.stopAutomation()
                                 }
                                 btn := material.Button(a.theme, &a.stopBtn, "STOP")
                                 btn.Background = color.NRGBA{R: 200, G: 0, B: 0, A: 255}
-                                if !a.running {
-                                        btn.Background = color.NRGBA{R: 100, G: 100, B: 100, A: 255}
+                                if state != automation.StateRunning && state != automation.StatePaused {
+                                        btn.Background = gray
                                         gtx = gtx.Disabled()
                                 }
                                 return btn.Layout(gtx)
                         }),
                         layout.Rigid(layout.Spacer{Width: unit.Dp(32)}.Layout),
                         layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-                                status := "Остановлен"
-                                statusColor := color.NRGBA{R: 200, G: 0, B: 0, A: 255}
-                                if a.running {
-                                        status = "Работает"
-                                        statusColor = color.NRGBA{R: 0, G: 150, B: 0, A: 255}
-                                }
+                                status, statusColor := stateLabel(state)
                                 label := material.H6(a.theme, fmt.Sprintf("Статус: %s", status))
                                 label.Color = statusColor
                                 return label.Layout(gtx)
@@ -220,6 +291,21 @@ func (a *App) controls(gtx layout.Context) layout.Dimensions {
         })
 }
 
+func stateLabel(state automation.State) (string, color.NRGBA) {
+        switch state {
+        case automation.StateRunning:
+                return "Работает", color.NRGBA{R: 0, G: 150, B: 0, A: 255}
+        case automation.StatePaused:
+                return "Пауза", color.NRGBA{R: 200, G: 130, B: 0, A: 255}
+        case automation.StateStopping:
+                return "Останавливается", color.NRGBA{R: 200, G: 130, B: 0, A: 255}
+        case automation.StateFailed:
+                return "Ошибка", color.NRGBA{R: 200, G: 0, B: 0, A: 255}
+        default:
+                return "Остановлен", color.NRGBA{R: 200, G: 0, B: 0, A: 255}
+        }
+}
+
 func (a *App) settingsPanel(gtx layout.Context) layout.Dimensions {
         return layout.UniformInset(unit.Dp(16)).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
                 return layout.Flex{
@@ -272,10 +358,40 @@ func (a *App) settingsPanel(gtx layout.Context) layout.Dimensions {
                                         }),
                                 )
                         }),
+                        layout.Rigid(layout.Spacer{Height: unit.Dp(8)}.Layout),
+                        layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+                                return layout.Flex{
+                                        Axis:      layout.Horizontal,
+                                        Alignment: layout.Middle,
+                                }.Layout(gtx,
+                                        layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+                                                return a.inputField(gtx, "Монитор (№ или «все»):", &a.displayEditor, 60)
+                                        }),
+                                        layout.Rigid(layout.Spacer{Width: unit.Dp(16)}.Layout),
+                                        layout.Flexed(1, a.displayList),
+                                )
+                        }),
                 )
         })
 }
 
+// displayList shows the detected monitors with their bounds in the
+// coordinates used by the search area and the clicks.
+func (a *App) displayList(gtx layout.Context) layout.Dimensions {
+        text := "Мониторы не найдены"
+        if len(a.displays) > 0 {
+                parts := make([]string, len(a.displays))
+                for i, display := range a.displays {
+                        parts[i] = fmt.Sprintf("%d: %s", i, displayBounds(display))
+                }
+                text = "Мониторы: " + strings.Join(parts, "; ")
+        }
+
+        lbl := material.Body2(a.theme, text)
+        lbl.Color = color.NRGBA{R: 80, G: 80, B: 80, A: 255}
+        return lbl.Layout(gtx)
+}
+
 func (a *App) inputField(gtx layout.Context, label string, editor *widget.Editor, width int) layout.Dimensions {
         return layout.Flex{
                 Axis:      layout.Horizontal,
@@ -288,7 +404,7 @@ func (a *App) inputField(gtx layout.Context, label string, editor *widget.Editor
                 }),
                 layout.Rigid(layout.Spacer{Width: unit.Dp(8)}.Layout),
                 layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-                        if a.running {
+                        if a.engine.State().Active() {
                                 gtx = gtx.Disabled()
                         }
                         gtx.Constraints.Min.X = gtx.Dp(unit.Dp(width))
@@ -376,58 +492,80 @@ func (a *App) applySettings() {
                 }
         }
 
+        displayText := strings.TrimSpace(a.displayEditor.Text())
+        if strings.EqualFold(displayText, "все") || strings.EqualFold(displayText, "all") {
+                a.config.Display = automation.DisplayAll
+        } else if display, err := strconv.Atoi(displayText); err == nil && display >= automation.DisplayAll {
+                a.config.Display = display
+        }
+
         if err := a.config.Save(configFile); err != nil {
                 log.Printf("Ошибка сохранения конфигурации: %v", err)
         }
 }
 
-func (a *App) startAutomation() {
-        a.running = true
-        ctx, cancel := context.WithCancel(context.Background())
-        a.statusCtx = ctx
-        a.cancelFn = cancel
-
-        a.statusChan <- automation.Status{
-                Timestamp: time.Now(),
-                Message:   "=== ЗАПУСК АВТОМАТИЗАЦИИ ===",
-                Level:     "info",
-        }
-        a.statusChan <- automation.Status{
-                Timestamp: time.Now(),
-                Message:   fmt.Sprintf("Область поиска: X=%d-%d, Y=%d-%d", a.config.ColorX1, a.config.ColorX2, a.config.ColorY1, a.config.ColorY2),
-                Level:     "info",
-        }
-        a.statusChan <- automation.Status{
-                Timestamp: time.Now(),
-                Message:   fmt.Sprintf("Целевой цвет: #%06X (допуск: ±%d)", a.config.TargetColor, a.config.ShadeVariation),
-                Level:     "info",
+// receiveConfig is called by the HTTP API from its own goroutine; the window
+// takes the configuration over on the next frame.
+func (a *App) receiveConfig(config automation.Config) {
+        a.pendingMutex.Lock()
+        a.pendingConfig = &config
+        a.pendingMutex.Unlock()
+
+        a.window.Invalidate()
+}
+
+func (a *App) applyPendingConfig() {
+        a.pendingMutex.Lock()
+        config := a.pendingConfig
+        a.pendingConfig = nil
+        a.pendingMutex.Unlock()
+
+        if config == nil {
+                return
         }
-        a.statusChan <- automation.Status{
-                Timestamp: time.Now(),
-                Message:   fmt.Sprintf("Изображения: Good=%s, Bad=%s", a.config.GoodImagePath, a.config.BadImagePath),
-                Level:     "info",
+        a.config = *config
+        a.setEditors(a.config)
+        if err := a.config.Save(configFile); err != nil {
+                log.Printf("Ошибка сохранения конфигурации: %v", err)
         }
-        a.statusChan <- automation.Status{
-                Timestamp: time.Now(),
-                Message:   fmt.Sprintf("Интервал проверки: %d сек, Порог совпад.: %.0f%%", a.config.LoopDelay, a.config.MatchThreshold*100),
-                Level:     "info",
+        a.logf("Конфигурация обновлена через HTTP API (правил: %d)", len(a.config.Rules))
+}
+
+func (a *App) startAutomation() {
+        a.logf("=== ЗАПУСК АВТОМАТИЗАЦИИ ===")
+        a.logf("Экран: %s", automation.DisplayString(a.config.Display))
+        a.logf("Область поиска: X=%d-%d, Y=%d-%d", a.config.ColorX1, a.config.ColorX2, a.config.ColorY1, a.config.ColorY2)
+        a.logf("Условие цвета: %s", a.config.ColorConditionString())
+        a.logf("Целевой цвет: #%06X (модель: %s)", a.config.TargetColor, a.config.ColorModelString())
+        a.logf("Правил: %d", len(a.config.Rules))
+        for i, rule := range a.config.Rules {
+                a.logf("  %d. %s", i+1, rule)
         }
+        a.logf("Интервал проверки: %d сек, Порог совпад.: %.0f%% (%s)", a.config.LoopDelay, a.config.MatchThreshold*100, a.config.MatchMetric)
 
-        go automation.Run(ctx, a.config, a.statusChan)
+        a.engine.SetConfig(a.config)
+        if err := a.engine.Start(context.Background()); err != nil {
+                log.Printf("Ошибка запуска автоматизации: %v", err)
+                return
+        }
 
         log.Println("Автоматизация запущена")
 }
 
-func (a *App) stopAutomation() {
-        if a.cancelFn != nil {
-                a.cancelFn()
-                a.cancelFn = nil
+func (a *App) togglePause() {
+        var err error
+        if a.engine.State() == automation.StatePaused {
+                err = a.engine.Resume()
+        } else {
+                err = a.engine.Pause()
         }
-        a.running = false
-        a.statusChan <- automation.Status{
-                Timestamp: time.Now(),
-                Message:   "=== ОСТАНОВКА АВТОМАТИЗАЦИИ ===",
-                Level:     "info",
+        if err != nil {
+                log.Printf("Ошибка: %v", err)
         }
+}
+
+func (a *App) stopAutomation() {
+        a.engine.Stop()
+        a.logf("=== ОСТАНОВКА АВТОМАТИЗАЦИИ ===")
         log.Println("Автоматизация остановлена")
 }
@@ -0,0 +1,12 @@
+//go:build headless
+
+package main
+
+import "errors"
+
+// Built with -tags headless the binary links neither the GUI toolkit nor
+// robotgo, so it builds and runs on machines without a display server or its
+// headers. Input actions fail there; run and once are meant for --dry-run.
+func runGUI() error {
+	return errors.New("графический интерфейс не включен в эту сборку, используйте команды run, once, validate, match")
+}
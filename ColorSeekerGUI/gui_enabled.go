@@ -0,0 +1,9 @@
+//go:build !headless
+
+package main
+
+import "code-rewrite-runner/gui"
+
+func runGUI() error {
+	return gui.NewApp().Run()
+}
@@ -4,19 +4,23 @@ import (
 	"log"
 	"os"
 
-	"code-rewrite-runner/gui"
+	"code-rewrite-runner/cli"
 )
 
 func main() {
 	log.SetFlags(log.Ltime | log.Lshortfile)
 
+	// Any argument selects the headless command line mode.
+	if len(os.Args) > 1 {
+		os.Exit(cli.Run(os.Args[1:]))
+	}
+
 	if err := checkImageFiles(); err != nil {
 		log.Printf("Внимание: %v", err)
 		log.Println("Программа продолжит работу, но убедитесь что файлы Good.png и bad.png существуют перед запуском автоматизации.")
 	}
 
-	app := gui.NewApp()
-	if err := app.Run(); err != nil {
+	if err := runGUI(); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,211 @@
+// Package render turns automation events into log text and JSON. The GUI log,
+// the command line and the HTTP API all share it.
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"code-rewrite-runner/automation"
+)
+
+// Line renders e as one log line with its time, as shown in the GUI.
+func Line(e automation.Event) string {
+	return fmt.Sprintf("[%s] %s", automation.EventHeader(e).Time.Format("15:04:05"), Text(e))
+}
+
+// Text renders e as a human readable Russian message.
+func Text(e automation.Event) string {
+	switch e := e.(type) {
+	case *automation.EngineStateChanged:
+		switch e.State {
+		case automation.StateRunning:
+			return "▶ Автоматизация работает"
+		case automation.StatePaused:
+			return "⏸ Автоматизация приостановлена"
+		case automation.StateStopping:
+			return "Автоматизация останавливается..."
+		case automation.StateStopped:
+			return "Автоматизация остановлена"
+		case automation.StateFailed:
+			return "✗ Автоматизация завершилась сбоем"
+		default:
+			return fmt.Sprintf("Состояние автоматизации: %s", e.State)
+		}
+
+	case *automation.IterationStarted:
+		return fmt.Sprintf("=== Итерация #%d ===", e.Iteration)
+
+	case *automation.FrameCaptured:
+		return fmt.Sprintf("  Снимок экрана: X=%d-%d, Y=%d-%d (%d×%d), %d мс",
+			e.Rect.Min.X, e.Rect.Max.X-1, e.Rect.Min.Y, e.Rect.Max.Y-1, e.Rect.Dx(), e.Rect.Dy(), e.Duration.Milliseconds())
+
+	case *automation.RuleMatched:
+		if e.Name == "" {
+			return fmt.Sprintf("→ Правило #%d", e.Index+1)
+		}
+		return fmt.Sprintf("→ Правило: %s", e.Name)
+
+	case *automation.ColorProbeResult:
+		if e.Found {
+			return fmt.Sprintf("✓ Цвет #%06X найден: %d пикс. (%.1f%%), первый X=%d, Y=%d, центр X=%d, Y=%d",
+				e.Target, e.Matched, e.Coverage, e.First.X, e.First.Y, e.Centroid.X, e.Centroid.Y)
+		}
+		condition := automation.Config{
+			ColorCondition:  e.Condition,
+			ColorMinPixels:  e.MinPixels,
+			ColorMinPercent: e.MinPercent,
+		}.ColorConditionString()
+		return fmt.Sprintf("✗ Цвет #%06X не найден (%d пикс., %.1f%%, условие: %s)",
+			e.Target, e.Matched, e.Coverage, condition)
+
+	case *automation.TemplateMatchResult:
+		region := fmt.Sprintf("область X=%d-%d, Y=%d-%d, %d мс",
+			e.Region.Min.X, e.Region.Max.X-1, e.Region.Min.Y, e.Region.Max.Y-1, e.Duration.Milliseconds())
+		if s := e.Stats; s.Abandoned > 0 {
+			region += fmt.Sprintf(", досрочно отброшено %d из %d позиций, сравнено %.0f%% пикселей",
+				s.Abandoned, s.Candidates, float64(s.Pixels)*100/float64(s.Pixels+s.Skipped))
+		}
+		switch {
+		case e.All && e.Found:
+			return fmt.Sprintf("✓ Шаблон %s: найдено совпадений: %d, лучшее X=%d, Y=%d (точность: %.0f%%; %s)",
+				e.Path, len(e.Matches), e.Match.Location.X, e.Match.Location.Y, e.Match.Score*100, region)
+		case e.Found:
+			return fmt.Sprintf("✓ Шаблон %s найден: X=%d, Y=%d (точность: %.0f%%, масштаб: %.2f; %s)",
+				e.Path, e.Match.Location.X, e.Match.Location.Y, e.Match.Score*100, e.Match.Scale, region)
+		case e.All:
+			return fmt.Sprintf("✗ Шаблон %s не найден (%s)", e.Path, region)
+		default:
+			return fmt.Sprintf("✗ Шаблон %s не найден (лучшая точность: %.0f%%; %s)", e.Path, e.Match.Score*100, region)
+		}
+
+	case *automation.ActionPerformed:
+		return action(e)
+
+	case *automation.VerifyResult:
+		switch {
+		case e.Skipped:
+			return fmt.Sprintf("⚠ Для действия %s не задано условие проверки, проверка пропущена", e.Action.Type)
+		case e.Passed:
+			return fmt.Sprintf("✓ Проверка «%s» пройдена (попытка %d/%d)", e.Expect, e.Attempt, e.Attempts)
+		case e.Final && e.Err != "":
+			return fmt.Sprintf("✗✗ Действие «%s» не подтверждено после %d попыток: проверить «%s» не удалось: %s", e.Action, e.Attempts, e.Expect, e.Err)
+		case e.Final:
+			return fmt.Sprintf("✗✗ Действие «%s» не дало результата после %d попыток: %s", e.Action, e.Attempts, e.Expect)
+		case e.Err != "":
+			return fmt.Sprintf("⚠ Проверить «%s» не удалось (попытка %d/%d): %s, повтор через %d мс",
+				e.Expect, e.Attempt, e.Attempts, e.Err, e.Retry.Milliseconds())
+		default:
+			return fmt.Sprintf("⚠ Проверка «%s» не пройдена (попытка %d/%d), повтор через %d мс",
+				e.Expect, e.Attempt, e.Attempts, e.Retry.Milliseconds())
+		}
+
+	case *automation.EngineError:
+		switch e.Op {
+		case automation.OpCapture:
+			return fmt.Sprintf("✗ Ошибка захвата экрана: %s", e.Err)
+		case automation.OpTemplate:
+			return fmt.Sprintf("✗ Шаблон %s: %s", e.Path, e.Err)
+		case automation.OpInput:
+			return fmt.Sprintf("✗ Ошибка нажатия %s", e.Err)
+		case automation.OpPanic:
+			return fmt.Sprintf("✗ Сбой автоматизации: %s", e.Err)
+		default:
+			return fmt.Sprintf("✗ %s", e.Err)
+		}
+
+	default:
+		return e.Kind()
+	}
+}
+
+func action(e *automation.ActionPerformed) string {
+	a := e.Action
+
+	match := ""
+	if e.Match != nil {
+		match = fmt.Sprintf(" (точность: %.0f%%, масштаб: %.2f)", e.Match.Score*100, e.Match.Scale)
+	}
+	template := "?"
+	if a.Template != nil {
+		template = a.Template.Path
+	}
+
+	switch a.Type {
+	case automation.ActionClick:
+		text := fmt.Sprintf("✓ Клик по %s: X=%d, Y=%d%s", template, e.Point.X, e.Point.Y, match)
+		if buttonName(a.Button) != "left" || a.Double || len(a.Modifiers) > 0 {
+			text += fmt.Sprintf(", кнопка: %s, двойной: %t, модификаторы: %v", buttonName(a.Button), a.Double, a.Modifiers)
+		}
+		if (a.Anchor != "" && a.Anchor != automation.AnchorCenter) || a.OffsetX != 0 || a.OffsetY != 0 ||
+			a.OffsetFracX != 0 || a.OffsetFracY != 0 {
+			anchor := a.Anchor
+			if anchor == "" {
+				anchor = automation.AnchorCenter
+			}
+			text += fmt.Sprintf(", якорь: %s, смещение: %+d,%+d / %+.2f,%+.2f", anchor, a.OffsetX, a.OffsetY, a.OffsetFracX, a.OffsetFracY)
+		}
+		return text
+	case automation.ActionHold:
+		return fmt.Sprintf("✓ Удержание на %s: X=%d, Y=%d, кнопка: %s, %d мс%s",
+			template, e.Point.X, e.Point.Y, buttonName(a.Button), a.DurationMs, match)
+	case automation.ActionKey:
+		key := a.Key
+		if len(a.Modifiers) > 0 {
+			key = strings.Join(append(append([]string(nil), a.Modifiers...), key), "+")
+		}
+		return fmt.Sprintf("✓ Клавиша: %s", key)
+	case automation.ActionType:
+		return fmt.Sprintf("✓ Ввод текста: %q", a.Text)
+	case automation.ActionScroll:
+		where := "в текущей позиции"
+		if a.Template != nil {
+			where = fmt.Sprintf("над %s: X=%d, Y=%d", template, e.Point.X, e.Point.Y)
+		}
+		return fmt.Sprintf("✓ Прокрутка: %d,%d (%s)", a.ScrollX, a.ScrollY, where)
+	case automation.ActionDrag:
+		return fmt.Sprintf("✓ Перетаскивание: X=%d, Y=%d → X=%d, Y=%d, кнопка: %s",
+			e.Point.X, e.Point.Y, e.To.X, e.To.Y, buttonName(a.Button))
+	case automation.ActionWait:
+		return fmt.Sprintf("  Пауза: %d мс", a.DurationMs)
+	default:
+		return fmt.Sprintf("✓ Действие %s", a.Type)
+	}
+}
+
+func buttonName(button string) string {
+	if button == "" {
+		return "left"
+	}
+	return button
+}
+
+// JSON encodes e as one object: its own fields plus "type" (Event.Kind) and
+// "level".
+func JSON(e automation.Event) ([]byte, error) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+
+	head, err := json.Marshal(struct {
+		Type  string           `json:"type"`
+		Level automation.Level `json:"level"`
+	}{e.Kind(), e.Level()})
+	if err != nil {
+		return nil, err
+	}
+
+	// Both are JSON objects: splice the fields of body into head.
+	var buf bytes.Buffer
+	buf.Write(head[:len(head)-1])
+	if len(body) > 2 {
+		buf.WriteByte(',')
+		buf.Write(body[1:])
+	} else {
+		buf.WriteByte('}')
+	}
+	return buf.Bytes(), nil
+}